@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Reply for a comparison that can't be understood
+const compareUsage = "Usage: !compare <stage name> (ex: !compare Labyrinth)"
+
+// Most stage names suggested when a comparison matches more than one stage
+const maxSuggestions = 10
+
+// stageIdentity turns a stage name into the form used to match the same stage
+// across games, ignoring case, spacing and punctuation
+func stageIdentity(name string) string {
+	identity := ""
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			identity += string(r)
+		}
+	}
+	return identity
+}
+
+// stageIndex maps every stage identity to where the stage appears in the
+// records, skipping duplicate story stages: story floors marked N/A, and story
+// floors that are a challenge stage of the same game
+func stageIndex(games []string) map[string][]stageRef {
+	index := make(map[string][]stageRef)
+	// Challenge stages go in first so story floors can be checked against them
+	for _, story := range []bool{false, true} {
+		for _, sec := range recordSections() {
+			if !sec.IsTime || !hasGame(games, sec.Game) || strings.HasPrefix(sec.Category(), "Story") != story {
+				continue
+			}
+			section := records[sec.Key]
+			for level := 1; level < len(section); level++ {
+				record := section[level]
+				identity := stageIdentity(record.Name)
+				if identity == "" || record.Time == "N/A" || (story && inChallenge(index[identity], sec.Game)) {
+					continue
+				}
+				index[identity] = append(index[identity], stageRef{Game: sec.Game, Category: sec.Category(), Level: level})
+			}
+		}
+	}
+	return index
+}
+
+// inChallenge reports whether one of the stages is a challenge stage of game
+func inChallenge(stages []stageRef, game string) bool {
+	for _, stage := range stages {
+		if stage.Game == game && !strings.HasPrefix(stage.Category, "Story") {
+			return true
+		}
+	}
+	return false
+}
+
+// buildCompareReply shows a stage's records in every game it appears in, side
+// by side with how far each time is from the best one
+func buildCompareReply(message string, games []string) string {
+	name := strings.TrimSpace(strings.TrimPrefix(message, "!compare"))
+	identity := stageIdentity(name)
+	if identity == "" {
+		return compareUsage
+	}
+
+	index := stageIndex(games)
+	stages, ok := index[identity]
+	if !ok {
+		// Fall back to stages whose name contains what was asked for
+		var matches []string
+		for candidate := range index {
+			if strings.Contains(candidate, identity) {
+				matches = append(matches, candidate)
+			}
+		}
+		if len(matches) == 0 {
+			return "No stage is called " + name
+		}
+		if len(matches) > 1 {
+			names := make([]string, 0, len(matches))
+			for _, match := range matches {
+				names = append(names, stageName(index[match][0]))
+			}
+			sort.Strings(names)
+			if len(names) > maxSuggestions {
+				names = append(names[:maxSuggestions], "...")
+			}
+			return "More than one stage matches, did you mean: " + strings.Join(names, ", ")
+		}
+		stages = index[matches[0]]
+	}
+
+	// The fastest time is what every other time is compared against
+	best := -1.0
+	times := make([]float64, len(stages))
+	for i, stage := range stages {
+		times[i] = -1
+		record := records[stage.Game+stage.Category+"Time"][stage.Level]
+		if record.Holder == "" {
+			continue
+		}
+		if seconds, err := parseTimeValue(record.Time); err == nil {
+			times[i] = seconds
+			if best < 0 || seconds < best {
+				best = seconds
+			}
+		}
+	}
+
+	returnMessage := stageName(stages[0]) + "\n"
+	for i, stage := range stages {
+		returnMessage += gameDisplayName(stage.Game) + " " + stageCode(stage.Game, stage.Category, stage.Level) + ": " + retrieveRecordString(stage.Game, stage.Category, "Time", stage.Level)
+		if times[i] >= 0 && len(stages) > 1 {
+			if times[i] == best {
+				returnMessage += " [best]"
+			} else {
+				returnMessage += " [+" + formatSeconds(times[i]-best) + "]"
+			}
+		}
+		if score := retrieveRecordString(stage.Game, stage.Category, "Score", stage.Level); score != "" {
+			returnMessage += ", " + score
+		}
+		returnMessage += "\n"
+	}
+	return returnMessage
+}
+
+// stageName returns the name of a stage as written in the sheet
+func stageName(stage stageRef) string {
+	return records[stage.Game+stage.Category+"Time"][stage.Level].Name
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	discordgo "github.com/bwmarrin/discordgo"
+)
+
+// Reply for an export that can't be understood
+const exportUsage = "Usage: !export [csv|json|md] [game] [time|score] (ex: !export, !export md smb2 time)"
+
+// exportFormats maps the format names users can ask for to the file extension
+// and content type of the export
+var exportFormats = map[string][2]string{
+	"csv":      {"csv", "text/csv"},
+	"json":     {"json", "application/json"},
+	"md":       {"md", "text/markdown"},
+	"markdown": {"md", "text/markdown"},
+}
+
+// exportSections returns the sections to export, in sheet order, leaving out
+// other games and score types and sections that aren't loaded
+func exportSections(games []string, scoreType string) []section {
+	var sections []section
+	for _, sec := range recordSections() {
+		if _, ok := records[sec.Key]; ok && hasGame(games, sec.Game) && (scoreType == "" || sec.ScoreType() == scoreType) {
+			sections = append(sections, sec)
+		}
+	}
+	return sections
+}
+
+// exportRecords writes the records of the sections in a format: CSV with one
+// row per stage, JSON in the same format as the cache, or Markdown with a
+// table per game and difficulty or story world
+func exportRecords(format string, sections []section) (string, error) {
+	switch format {
+	case "csv":
+		return exportCSV(sections)
+	case "json":
+		snapshot := make(map[string][]Record)
+		for _, sec := range sections {
+			snapshot[sec.Key] = records[sec.Key]
+		}
+		data, err := json.MarshalIndent(snapshot, "", "\t")
+		return string(data) + "\n", err
+	case "md", "markdown":
+		return exportMarkdown(sections), nil
+	}
+	return "", fmt.Errorf("unknown export format %q (csv, json or md)", format)
+}
+
+// exportCSV writes one row per stage with a holder column left empty for
+// open records. Duplicate story stages are left out.
+func exportCSV(sections []section) (string, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"Game", "Category", "Stage", "Name", "Type", "Value", "Holder", "Video"})
+	for _, sec := range sections {
+		section := records[sec.Key]
+		for level := 1; level < len(section); level++ {
+			record := section[level]
+			if record.Time == "N/A" {
+				continue
+			}
+			writer.Write([]string{gameDisplayName(sec.Game), categoryLabel(sec.Game, sec.Category()), stageCode(sec.Game, sec.Category(), level), record.Name, sec.ScoreType(), record.Time, record.Holder, record.Video})
+		}
+	}
+	writer.Flush()
+	return buffer.String(), writer.Error()
+}
+
+// markdownCell escapes a table cell so it can't break the table
+func markdownCell(text string) string {
+	return strings.Replace(text, "|", "\\|", -1)
+}
+
+// exportMarkdown writes a heading per game and a table per difficulty or
+// story world and score type
+func exportMarkdown(sections []section) string {
+	text := ""
+	lastGame := ""
+	for _, sec := range sections {
+		if sec.Game != lastGame {
+			if lastGame != "" {
+				text += "\n"
+			}
+			text += "# " + gameDisplayName(sec.Game) + "\n"
+			lastGame = sec.Game
+		}
+		text += "\n## " + categoryLabel(sec.Game, sec.Category()) + " " + scoreTypeName(sec.IsTime) + "s\n\n"
+		text += "| Stage | Name | " + sec.ScoreType() + " | Holder | Video |\n"
+		text += "| --- | --- | --- | --- | --- |\n"
+		section := records[sec.Key]
+		for level := 1; level < len(section); level++ {
+			record := section[level]
+			if record.Time == "N/A" {
+				continue
+			}
+			video := ""
+			if record.Video != "" {
+				video = "[video](" + record.Video + ")"
+			}
+			text += "| " + stageCode(sec.Game, sec.Category(), level) + " | " + markdownCell(record.Name) + " | " + markdownCell(record.Time) + " | " + markdownCell(record.Holder) + " | " + video + " |\n"
+		}
+	}
+	return text
+}
+
+// buildExport handles !export, returning the export as a file to attach or a
+// reply explaining what is wrong
+func buildExport(message string, games []string) (*discordgo.File, string) {
+	format := "csv"
+	var args []string
+	for _, arg := range strings.Fields(message)[1:] {
+		if _, ok := exportFormats[strings.ToLower(arg)]; ok {
+			format = strings.ToLower(arg)
+		} else {
+			args = append(args, arg)
+		}
+	}
+	games, scoreType, ok := parseGameAndType(args, games)
+	if !ok {
+		return nil, exportUsage
+	}
+
+	sections := exportSections(games, scoreType)
+	if len(sections) == 0 {
+		return nil, "There are no records to export"
+	}
+	data, err := exportRecords(format, sections)
+	if err != nil {
+		return nil, "The records couldn't be exported: " + err.Error()
+	}
+	return &discordgo.File{Name: "records." + exportFormats[format][0], ContentType: exportFormats[format][1], Reader: strings.NewReader(data)}, ""
+}
+
+// sendExport attaches an export of the records to a message in the channel
+func sendExport(s *discordgo.Session, m *discordgo.MessageCreate) {
+	file, problem := buildExport(m.Content, allGames)
+	if problem != "" {
+		_, _ = s.ChannelMessageSend(m.ChannelID, problem)
+		return
+	}
+	content := "Records from the sheet"
+	if !lastFetch.IsZero() {
+		content += " as of " + lastFetch.UTC().Format(time.RFC1123)
+	}
+	_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Content: content, Files: []*discordgo.File{file}})
+	if err != nil {
+		fmt.Println("error sending the export,", err)
+	}
+}
+
+// runExport writes the records to a file or the terminal
+//
+//	scorebot export -format md -game smb2
+//	scorebot export -offline -o records.csv
+func runExport(args []string) {
+	exportFlags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := exportFlags.String("format", "csv", "Format to export in (csv, json or md)")
+	game := exportFlags.String("game", "", "Only export records for this game ("+gameNameList()+")")
+	scoreType := exportFlags.String("type", "", "Only export times or scores (time or score)")
+	offline := exportFlags.Bool("offline", false, "Use the cached records instead of fetching the sheet")
+	output := exportFlags.String("o", "", "File to write the export to instead of the terminal")
+	exportFlags.Parse(args)
+
+	games := commandGames(*game)
+	typeKey := ""
+	switch strings.ToLower(*scoreType) {
+	case "":
+	case "time":
+		typeKey = "Time"
+	case "score":
+		typeKey = "Score"
+	default:
+		fmt.Fprintf(os.Stderr, "unknown type %q (time or score)\n", *scoreType)
+		os.Exit(2)
+	}
+
+	loadCommandRecords(*offline)
+
+	data, err := exportRecords(strings.ToLower(*format), exportSections(games, typeKey))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *output == "" {
+		fmt.Print(data)
+		return
+	}
+	if err := ioutil.WriteFile(*output, []byte(data), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "error writing the export,", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExportJSONMatchesRecords(t *testing.T) {
+	loadFixtureRecords(t)
+
+	data, err := exportRecords("json", exportSections(allGames, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := make(map[string][]Record)
+	if err := json.Unmarshal([]byte(data), &exported); err != nil {
+		t.Fatal(err)
+	}
+	// Alternate tables aren't exported
+	expected := make(map[string][]Record)
+	for _, sec := range recordSections() {
+		if section, ok := records[sec.Key]; ok {
+			expected[sec.Key] = section
+		}
+	}
+	report := reconcileRecords("records", expected, "export", exported)
+	if len(report.Differences) != 0 || len(report.OnlyLeft) != 0 || len(report.OnlyRight) != 0 {
+		t.Errorf("the JSON export differs from the records:\n%s", formatReconcileReport(report))
+	}
+}
+
+func TestBuildExport(t *testing.T) {
+	loadFixtureRecords(t)
+
+	tests := []struct {
+		message     string
+		games       []string
+		name        string
+		contentType string
+		firstLine   string
+		problem     string
+	}{
+		{message: "!export", games: allGames, name: "records.csv", contentType: "text/csv", firstLine: "Game,Category,Stage,Name,Type,Value,Holder,Video"},
+		{message: "!export MD smb2 score", games: allGames, name: "records.md", contentType: "text/markdown", firstLine: "# SMB2"},
+		{message: "!export json time", games: allGames, name: "records.json", contentType: "application/json", firstLine: "{"},
+		{message: "!export smb1", games: []string{"SMB2"}, problem: exportUsage},
+		{message: "!export xml", games: allGames, problem: exportUsage},
+		{message: "!export", games: []string{}, problem: "There are no records to export"},
+	}
+	for _, test := range tests {
+		file, problem := buildExport(test.message, test.games)
+		if problem != test.problem {
+			t.Errorf("%s: problem %q, want %q", test.message, problem, test.problem)
+			continue
+		}
+		if problem != "" {
+			continue
+		}
+		if file.Name != test.name || file.ContentType != test.contentType {
+			t.Errorf("%s: file %s (%s), want %s (%s)", test.message, file.Name, file.ContentType, test.name, test.contentType)
+		}
+		data, err := ioutil.ReadAll(file.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if firstLine := strings.SplitN(string(data), "\n", 2)[0]; firstLine != test.firstLine {
+			t.Errorf("%s: starts with %q, want %q", test.message, firstLine, test.firstLine)
+		}
+	}
+
+	// Only the game and score type asked for are exported
+	file, _ := buildExport("!export smb2 score", allGames)
+	data, _ := ioutil.ReadAll(file.Reader)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n")[1:] {
+		if !strings.HasPrefix(line, "SMB2,") || !strings.Contains(line, ",Score,") {
+			t.Errorf("unexpected row %q", line)
+			break
+		}
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	loadFixtureRecords(t)
+
+	// A challenge section, a story world and a variant are enough to cover every row
+	var sections []section
+	for _, sec := range recordSections() {
+		if sec.Key == "SMB2BeginnerTime" || sec.Key == "SMB2BeginnerScore" || sec.Key == "SMB2Story3Time" || sec.Key == "BMExpertReverseTime" {
+			sections = append(sections, sec)
+		}
+	}
+
+	tests := []struct {
+		format string
+		has    []string
+		hasNot []string
+	}{
+		{"csv", []string{
+			"SMB2,Beginner,b1,Simple,Time,28.80,Ghost Ship,\n",
+			// Scores with commas are quoted
+			"SMB2,Beginner,b2,Hollow,Score,\"9,883\",Ghost Ship,https://www.twitch.tv/videos/241651417\n",
+			// Open records have no holder
+			"SMB2,Beginner,b7,Slopes,Time,,,\n",
+			"SMB2,World 3,s3-10,World 3-10,Time,24.96,CyclopsDragon,\n",
+			"Banana Mania,Expert Reverse,e10 reverse,Expert 10,Time,46.72,CyclopsDragon,\n",
+		}, []string{
+			// Duplicate story stages are left out
+			"s3-9",
+		}},
+		{"md", []string{
+			"# SMB2\n\n## Beginner times\n\n| Stage | Name | Time | Holder | Video |\n| --- | --- | --- | --- | --- |\n| b1 | Simple | 28.80 | Ghost Ship |  |\n",
+			"## Beginner scores\n",
+			"| b2 | Hollow | 9,883 | Ghost Ship | [video](https://www.twitch.tv/videos/241651417) |\n",
+			"## World 3 times\n",
+			"\n# Banana Mania\n\n## Expert Reverse times\n",
+			"| e10 reverse | Expert 10 | 46.72 | CyclopsDragon |  |\n",
+		}, []string{
+			"s3-9",
+		}},
+	}
+	for _, test := range tests {
+		data, err := exportRecords(test.format, sections)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, text := range test.has {
+			if !strings.Contains(data, text) {
+				t.Errorf("%s export doesn't have %q", test.format, text)
+			}
+		}
+		for _, text := range test.hasNot {
+			if strings.Contains(data, text) {
+				t.Errorf("%s export has %q", test.format, text)
+			}
+		}
+	}
+
+	if _, err := exportRecords("xml", sections); err == nil {
+		t.Error("an unknown format was exported")
+	}
+	if got := markdownCell("a|b"); got != "a\\|b" {
+		t.Errorf("markdownCell gave %q, want a\\|b", got)
+	}
+}
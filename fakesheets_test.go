@@ -0,0 +1,603 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	discordgo "github.com/bwmarrin/discordgo"
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// The spreadsheet id and access token the fake server accepts
+const (
+	fakeSheetID = "fixture"
+	fakeToken   = "fake-token"
+)
+
+// fakeSheets stands in for the Google token, Sheets and Drive endpoints,
+// serving a spreadsheet loaded from a fixture in testdata
+type fakeSheets struct {
+	server *httptest.Server
+
+	lock        sync.Mutex
+	spreadsheet *sheets.Spreadsheet
+	version     int64
+	ranges      []string
+	fields      string
+	updates     []*sheets.BatchUpdateSpreadsheetRequest
+}
+
+// newFakeSheets starts a fake server serving the named fixture
+func newFakeSheets(t *testing.T, fixture string) *fakeSheets {
+	fake := &fakeSheets{spreadsheet: loadFixture(t, fixture), version: 1}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", fake.serveToken)
+	mux.HandleFunc("/v4/spreadsheets/", fake.authorized(fake.serveSpreadsheet))
+	mux.HandleFunc("/drive/v3/files/", fake.authorized(fake.serveFile))
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// loadFixture reads a spreadsheet fixture from testdata
+func loadFixture(t *testing.T, fixture string) *sheets.Spreadsheet {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spreadsheet := &sheets.Spreadsheet{}
+	if err := json.Unmarshal(data, spreadsheet); err != nil {
+		t.Fatalf("%s: %v", fixture, err)
+	}
+	return spreadsheet
+}
+
+// loadFixtureRecords parses the fixture spreadsheet into records
+func loadFixtureRecords(t *testing.T) {
+	t.Helper()
+	store, issues := parseSpreadsheet(loadFixture(t, "spreadsheet.json"), nil)
+	if len(issues) != 0 {
+		t.Fatalf("unexpected issues parsing the fixture:\n%s", formatIssues("", issues))
+	}
+	records = store
+	t.Cleanup(func() { records = nil })
+}
+
+// serveToken hands out an access token for any service account assertion
+func (fake *fakeSheets) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.FormValue("assertion") == "" {
+		http.Error(w, "expected a service account assertion", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"access_token": "` + fakeToken + `", "token_type": "Bearer", "expires_in": 3600}`))
+}
+
+// authorized rejects requests without the token handed out by serveToken
+func (fake *fakeSheets) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+			http.Error(w, "missing access token", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// serveSpreadsheet answers spreadsheets.get and spreadsheets.batchUpdate
+func (fake *fakeSheets) serveSpreadsheet(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v4/spreadsheets/")
+	if r.Method == "POST" && strings.HasSuffix(id, ":batchUpdate") {
+		id = strings.TrimSuffix(id, ":batchUpdate")
+	}
+	if id != fakeSheetID {
+		http.Error(w, "no spreadsheet "+id, http.StatusNotFound)
+		return
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if r.Method == "POST" {
+		update := &sheets.BatchUpdateSpreadsheetRequest{}
+		if err := json.NewDecoder(r.Body).Decode(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fake.updates = append(fake.updates, update)
+		fake.applyUpdate(update)
+		writeTestJSON(w, map[string]string{"spreadsheetId": id})
+		return
+	}
+
+	fake.ranges = r.URL.Query()["ranges"]
+	fake.fields = r.URL.Query().Get("fields")
+	response, err := fake.selectRanges(fake.ranges)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeTestJSON(w, response)
+}
+
+// serveFile answers files.get with the spreadsheet's version
+func (fake *fakeSheets) serveFile(w http.ResponseWriter, r *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	writeTestJSON(w, map[string]string{
+		"id":           fakeSheetID,
+		"version":      strconv.FormatInt(fake.version, 10),
+		"modifiedTime": fmt.Sprintf("2020-01-01T00:00:%02d.000Z", fake.version),
+	})
+}
+
+var a1Range = regexp.MustCompile(`^'((?:[^']|'')*)'!([A-Z]+)(\d+):([A-Z]+)(\d*)$`)
+
+// selectRanges returns the parts of the spreadsheet covered by the A1 ranges
+// the way the API does, with one grid per range. Without ranges every tab is
+// returned whole, or just its properties if no grid data was asked for.
+func (fake *fakeSheets) selectRanges(ranges []string) (*sheets.Spreadsheet, error) {
+	if len(ranges) == 0 && !strings.Contains(fake.fields, "data") {
+		response := &sheets.Spreadsheet{SpreadsheetId: fakeSheetID}
+		for _, tab := range fake.spreadsheet.Sheets {
+			response.Sheets = append(response.Sheets, &sheets.Sheet{Properties: tab.Properties})
+		}
+		return response, nil
+	}
+	if len(ranges) == 0 {
+		return fake.spreadsheet, nil
+	}
+
+	response := &sheets.Spreadsheet{SpreadsheetId: fakeSheetID}
+	tabs := make(map[string]*sheets.Sheet)
+	for _, a1 := range ranges {
+		match := a1Range.FindStringSubmatch(a1)
+		if match == nil {
+			return nil, fmt.Errorf("unable to parse range: %s", a1)
+		}
+		title := strings.Replace(match[1], "''", "'", -1)
+		full := findSheet(fake.spreadsheet, title)
+		if full == nil {
+			return nil, fmt.Errorf("unable to parse range: %s", a1)
+		}
+		if tabs[title] == nil {
+			tabs[title] = &sheets.Sheet{Properties: full.Properties}
+			response.Sheets = append(response.Sheets, tabs[title])
+		}
+
+		startRow, _ := strconv.Atoi(match[3])
+		// A range without an end row goes to the bottom of the tab
+		endRow, err := strconv.Atoi(match[5])
+		if err != nil {
+			endRow = len(full.Data[0].RowData)
+		}
+		startCol, endCol := columnIndex(match[2]), columnIndex(match[4])
+		grid := &sheets.GridData{StartRow: int64(startRow - 1), StartColumn: int64(startCol)}
+		rowData := full.Data[0].RowData
+		for i := startRow - 1; i < endRow && i < len(rowData); i++ {
+			row := &sheets.RowData{}
+			for j := startCol; j <= endCol && rowData[i] != nil && j < len(rowData[i].Values); j++ {
+				row.Values = append(row.Values, rowData[i].Values[j])
+			}
+			grid.RowData = append(grid.RowData, row)
+		}
+		tabs[title].Data = append(tabs[title].Data, grid)
+	}
+	return response, nil
+}
+
+var hyperlinkFormula = regexp.MustCompile(`^=HYPERLINK\("((?:[^"]|"")*)", ([0-9.]+)(/86400)?\)$`)
+
+// applyUpdate makes the changes of an UpdateCells request to the spreadsheet
+// and bumps its version, so the next fetch sees them
+func (fake *fakeSheets) applyUpdate(update *sheets.BatchUpdateSpreadsheetRequest) {
+	for _, request := range update.Requests {
+		if request.UpdateCells == nil || request.UpdateCells.Start == nil {
+			continue
+		}
+		start := request.UpdateCells.Start
+		for _, tab := range fake.spreadsheet.Sheets {
+			if tab.Properties.SheetId != start.SheetId {
+				continue
+			}
+			rowData := tab.Data[0].RowData
+			for i, row := range request.UpdateCells.Rows {
+				r := int(start.RowIndex) + i
+				for j, cell := range row.Values {
+					c := int(start.ColumnIndex) + j
+					for len(rowData[r].Values) <= c {
+						rowData[r].Values = append(rowData[r].Values, &sheets.CellData{})
+					}
+					rowData[r].Values[c] = evaluateCell(cell)
+				}
+			}
+		}
+	}
+	fake.version++
+}
+
+// evaluateCell works out what a cell written by the bot would show
+func evaluateCell(cell *sheets.CellData) *sheets.CellData {
+	value := cell.UserEnteredValue
+	if value == nil {
+		return &sheets.CellData{}
+	}
+	if value.FormulaValue != nil {
+		// The label is a number, shown as a duration if it is a fraction of a day
+		if match := hyperlinkFormula.FindStringSubmatch(*value.FormulaValue); match != nil {
+			shown := match[2]
+			if match[3] != "" {
+				seconds, _ := strconv.ParseFloat(match[2], 64)
+				shown = formatSeconds(seconds)
+			}
+			return &sheets.CellData{FormattedValue: shown, Hyperlink: strings.Replace(match[1], `""`, `"`, -1)}
+		}
+		return &sheets.CellData{FormattedValue: "#ERROR!"}
+	}
+	if value.StringValue != nil {
+		return &sheets.CellData{FormattedValue: *value.StringValue}
+	}
+	return &sheets.CellData{}
+}
+
+// setCell changes a cell of the fake spreadsheet, as if someone edited it
+func (fake *fakeSheets) setCell(tab string, row int, col int, value string) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	findSheet(fake.spreadsheet, tab).Data[0].RowData[row].Values[col] = &sheets.CellData{FormattedValue: value}
+	fake.version++
+}
+
+// columnIndex turns a column name (ex: "AB") into a zero based index
+func columnIndex(name string) int {
+	col := 0
+	for _, letter := range name {
+		col = col*26 + int(letter-'A') + 1
+	}
+	return col - 1
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// A service account key for the token endpoint, generated once per test run
+var testKey []byte
+var testKeyOnce sync.Once
+
+// useFakeSheets points the bot at a fake server for the rest of the test and
+// starts it with no records loaded
+func useFakeSheets(t *testing.T, fake *fakeSheets) {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	})
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(keyFile, testKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, email, "bot@example.iam.gserviceaccount.com")
+	setFlag(t, privateKeyFile, keyFile)
+	setFlag(t, sheet, fakeSheetID)
+	setFlag(t, tokenURL, fake.server.URL+"/token")
+	setFlag(t, sheetsEndpoint, fake.server.URL+"/")
+	setFlag(t, driveEndpoint, fake.server.URL+"/drive/v3/")
+	setFlag(t, cacheFile, filepath.Join(dir, "records.json"))
+	setFlag(t, headersFile, filepath.Join(dir, "headers.json"))
+	setFlag(t, writeLog, filepath.Join(dir, "writeback.log"))
+
+	records = nil
+	parseErrors = nil
+	knownHeaders = make(map[string][]string)
+	fullGameBoards = make(map[string]*fullGameBoard)
+	stageRankings = make(map[string][]rankedRun)
+	initializeSheets()
+}
+
+// setFlag changes a flag's value until the end of the test
+func setFlag(t *testing.T, value *string, newValue string) {
+	old := *value
+	*value = newValue
+	t.Cleanup(func() { *value = old })
+}
+
+func TestFetchFromFakeSheets(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+
+	updateInformation()
+	if len(parseErrors) != 0 {
+		t.Fatalf("unexpected sheet issues:\n%s", formatIssues("", parseErrors))
+	}
+	sectionCount := 0
+	for _, tab := range sheetLayout {
+		if findSheet(fake.spreadsheet, tab.Title) != nil {
+			sectionCount += len(tab.Sections)
+		}
+	}
+	if len(records) != sectionCount {
+		t.Errorf("loaded %d sections, want %d", len(records), sectionCount)
+	}
+
+	// Only the sections of tabs in the sheet should have been asked for
+	present := make(map[string]bool)
+	for _, tab := range fake.spreadsheet.Sheets {
+		present[tab.Properties.Title] = true
+	}
+	if strings.Join(fake.ranges, ",") != strings.Join(fetchRanges(present), ",") {
+		t.Errorf("requested ranges %v, want %v", fake.ranges, fetchRanges(present))
+	}
+	// One range per tab keeps the request URL short
+	if ranges := sectionRanges(present); len(ranges) > len(sheetLayout) || ranges[0] != "'SMB1 Time'!C3:Y68" {
+		t.Errorf("requested %d ranges for %d tabs, starting with %s", len(ranges), len(sheetLayout), ranges[0])
+	}
+	if fake.fields != sectionFields {
+		t.Errorf("requested fields %q, want %q", fake.fields, sectionFields)
+	}
+	if lastVersion != "1 2020-01-01T00:00:01.000Z" {
+		t.Errorf("lastVersion = %q", lastVersion)
+	}
+
+	// The whole path from the sheet to a reply
+	queries := map[string]string{
+		"!b1":                 "SMB1 (Beginner 1): Time: 21.75 (CyclopsDragon) (Twitch: <https://www.twitch.tv/videos/329283573>)",
+		"!s3-9":               "Duplicate Stage",
+		"!fg smb2 story any%": "2. 1:02:03.45 by Alex on 2020-03-01",
+		"!smb1 b1 top3":       "1. 21.75 (Nambo)\n3. 22.00 (Alex)",
+	}
+	for query, want := range queries {
+		if reply := buildReply(query, allGames); !strings.Contains(reply, want) {
+			t.Errorf("%s: reply %q doesn't contain %q", query, reply, want)
+		}
+	}
+}
+
+func TestUpdateWhileReplying(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+	updateInformation()
+
+	// Polling and !update fetch while replies read the records (run with -race)
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			updateInformation()
+			done <- true
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		recordsLock.RLock()
+		reply := buildReply("!b1", allGames)
+		recordsLock.RUnlock()
+		if !strings.Contains(reply, "21.75") {
+			t.Errorf("reply during an update was %q", reply)
+		}
+	}
+	<-done
+	<-done
+}
+
+func TestShortTabIsReported(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+
+	// Someone deleted the last rows of SMB1 Expert's extra stages, which the
+	// API leaves out instead of returning them empty
+	tab := findSheet(fake.spreadsheet, "SMB1 Time")
+	tab.Data[0].RowData = tab.Data[0].RowData[:66]
+	updateInformation()
+
+	want := []string{"'SMB1 Time' (SMB1ExpertExtraTime): expected 10 rows starting at row 59, found 8"}
+	var got []string
+	for _, issue := range parseErrors {
+		got = append(got, issue.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got sheet issues\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHeaderChangeAfterRestart(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+	updateInformation()
+	header := cellText(findSheet(fake.spreadsheet, "SMB2 Challenge Time").Data[0].RowData, 2, 2)
+
+	// The headers of the good sheet outlive a restart
+	knownHeaders = make(map[string][]string)
+	records = nil
+	if err := loadKnownHeaders(); err != nil {
+		t.Fatal(err)
+	}
+	fake.setCell("SMB2 Challenge Time", 2, 2, "Beginner (New)")
+	updateInformation()
+
+	want := "'SMB2 Challenge Time'!C3 (SMB2BeginnerTime): header changed from \"" + header + "\" to \"Beginner (New)\""
+	if len(parseErrors) != 1 || parseErrors[0].String() != want {
+		t.Errorf("got sheet issues\n%s\nwant\n%s", formatIssues("", parseErrors), want)
+	}
+}
+
+func TestBrokenSectionKeepsPreviousRecords(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+
+	updateInformation()
+	before := records["SMB2ExpertTime"][3]
+
+	// Someone types a holder into the time column
+	fake.setCell("SMB2 Challenge Time", 5, 13, "Alex")
+	fake.setCell("SMB2 Challenge Time", 3, 3, "12.34")
+	updateInformation()
+
+	if len(parseErrors) != 1 || parseErrors[0].String() != `'SMB2 Challenge Time'!N6 (SMB2ExpertTime): expected a time, found "Alex"` {
+		t.Errorf("unexpected sheet issues:\n%s", formatIssues("", parseErrors))
+	}
+	if records["SMB2ExpertTime"][3] != before {
+		t.Errorf("broken section changed from %+v to %+v", before, records["SMB2ExpertTime"][3])
+	}
+	if records["SMB2BeginnerTime"][1].Time != "12.34" {
+		t.Errorf("other sections weren't updated, SMB2 b1 is %q", records["SMB2BeginnerTime"][1].Time)
+	}
+}
+
+func TestWriteBackToFakeSheets(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+	updateInformation()
+
+	old := records["SMB2ExpertTime"][3]
+	sub := submission{ID: 1, Username: "jimmy", Holder: `Jimmy "Fast" Smith`, Moderator: "Alex", Game: "SMB2", Category: "Expert", Level: 3, IsTime: true, Value: "10.01", Video: "https://youtu.be/dQw4w9WgXcQ", Previous: old.Time + " by " + old.Holder}
+
+	// A dry run only reads the sheet
+	*writeDryRun = true
+	defer func() { *writeDryRun = false }()
+	reply, err := writeRecord(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reply, "Dry run: would write 10.01 by Jimmy \"Fast\" Smith to 'SMB2 Challenge Time'!N6:O6") || len(fake.updates) != 0 {
+		t.Errorf("dry run replied %q and sent %d updates", reply, len(fake.updates))
+	}
+
+	*writeDryRun = false
+	reply, err = writeRecord(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.updates) != 1 {
+		t.Fatalf("sent %d updates, want 1", len(fake.updates))
+	}
+	cells := fake.updates[0].Requests[0].UpdateCells
+	if cells.Start.SheetId != 1002 || cells.Start.RowIndex != 5 || cells.Start.ColumnIndex != 13 {
+		t.Errorf("wrote to %+v, want sheet 1002 row 5 column 13 (%s)", *cells.Start, reply)
+	}
+	// The label is a number, not a string
+	if formula := *cells.Rows[0].Values[0].UserEnteredValue.FormulaValue; formula != `=HYPERLINK("https://youtu.be/dQw4w9WgXcQ", 10.01)` {
+		t.Errorf("wrote the formula %s", formula)
+	}
+
+	// The next fetch picks up the new record
+	updateInformation()
+	record := records["SMB2ExpertTime"][3]
+	if record.Time != "10.01" || record.Holder != sub.Holder || record.Video != sub.Video {
+		t.Errorf("record after write-back is %+v", record)
+	}
+
+	// An approval of a submission that beat the old record doesn't overwrite the new one
+	stale := sub
+	stale.ID = 2
+	stale.Value = "10.50"
+	_, err = writeRecord(stale)
+	if err == nil || !strings.Contains(err.Error(), "the record changed from "+sub.Previous+" to 10.01 by "+sub.Holder) {
+		t.Errorf("writing over a newer record gave %v", err)
+	}
+	if len(fake.updates) != 1 {
+		t.Errorf("sent %d updates after a stale approval, want 1", len(fake.updates))
+	}
+
+	// m:ss times are written as durations
+	story := records["SMB2Story1Time"][10]
+	long := submission{ID: 3, Holder: "Alex", Game: "SMB2", Category: "Story1", Level: 10, IsTime: true, Value: "1:01.50", Video: "https://youtu.be/dQw4w9WgXcQ", Previous: currentRecord(story.Time, story.Holder)}
+	if _, err := writeRecord(long); err != nil {
+		t.Fatal(err)
+	}
+	updateInformation()
+	if got := records["SMB2Story1Time"][10].Time; got != "1:01.50" {
+		t.Errorf("a m:ss time was written as %q", got)
+	}
+
+	// Every attempt is in the audit log, with the one that was refused
+	data, err := ioutil.ReadFile(*writeLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[2], `"error":"the record changed`) {
+		t.Errorf("audit log has %d lines, want 4 with the refused write third:\n%s", len(lines), data)
+	}
+}
+
+func TestApproveNeedsSheetHolder(t *testing.T) {
+	useFakeSheets(t, newFakeSheets(t, "spreadsheet.json"))
+	updateInformation()
+	dir := t.TempDir()
+	setFlag(t, submissionsFile, filepath.Join(dir, "submissions.json"))
+	setFlag(t, submissionLog, filepath.Join(dir, "submissions.log"))
+	*writeDryRun = true
+	defer func() { *writeDryRun = false }()
+	oldSubmissions := submissions
+	defer func() { submissions = oldSubmissions }()
+	old := records["SMB2ExpertTime"][3]
+	pending := submission{Game: "SMB2", Category: "Expert", Level: 3, IsTime: true, Value: "10.01", Previous: old.Time + " by " + old.Holder, Status: statusPending}
+	submissions = []submission{pending, pending}
+	submissions[0].ID, submissions[0].Username = 1, "jimmy"
+	submissions[1].ID, submissions[1].Username = 2, "cyclopsdragon"
+
+	// Someone not in the sheet yet needs the holder name from the moderator
+	if reply := decideSubmission(1, true, "Alex", "", ""); !strings.HasPrefix(reply, "jimmy doesn't hold any records in the sheet") || submissions[0].Status != statusPending {
+		t.Errorf("approving without a holder replied %q and left it %s", reply, submissions[0].Status)
+	}
+	if name := sheetHolderName("cyclopsdragon"); name != "CyclopsDragon" {
+		t.Errorf("cyclopsdragon is %q in the sheet, want CyclopsDragon", name)
+	}
+
+	// Or the sheet's spelling of the submitter's name is used
+	if reply := reviewCommand("!approve 1 Jimmy Smith", &discordgo.User{Username: "Alex"}); !strings.Contains(reply, "would write 10.01 by Jimmy Smith") {
+		t.Errorf("approving with a holder replied %q", reply)
+	}
+	if reply := decideSubmission(2, true, "Alex", "", ""); !strings.Contains(reply, "would write 10.01 by CyclopsDragon") {
+		t.Errorf("approving a holder in the sheet replied %q", reply)
+	}
+	if submissions[0].Status != statusApproved || submissions[0].Holder != "Jimmy Smith" || submissions[1].Holder != "CyclopsDragon" {
+		t.Errorf("approved submissions are %+v", submissions)
+	}
+}
+
+func TestFailedWriteStaysPending(t *testing.T) {
+	fake := newFakeSheets(t, "spreadsheet.json")
+	useFakeSheets(t, fake)
+	updateInformation()
+	dir := t.TempDir()
+	setFlag(t, submissionsFile, filepath.Join(dir, "submissions.json"))
+	setFlag(t, submissionLog, filepath.Join(dir, "submissions.log"))
+	*writeBack = true
+	defer func() { *writeBack = false }()
+	oldSubmissions := submissions
+	defer func() { submissions = oldSubmissions }()
+	old := records["SMB2ExpertTime"][3]
+	submissions = []submission{{ID: 1, Username: "jimmy", Game: "SMB2", Category: "Expert", Level: 3, IsTime: true, Value: "10.01", Previous: "11.00 by Nambo", Status: statusPending}}
+
+	// The record changed since it was submitted, so nothing is written and it
+	// can still be approved or rejected
+	reply := decideSubmission(1, true, "Alex", "", "Jimmy")
+	if !strings.Contains(reply, "couldn't be written to the sheet, so it is still pending") || submissions[0].Status != statusPending || len(fake.updates) != 0 {
+		t.Errorf("a failed write replied %q, left it %s and sent %d updates", reply, submissions[0].Status, len(fake.updates))
+	}
+
+	// Once the write works (the record is the one it beat again) the approval is saved
+	submissions[0].Previous = old.Time + " by " + old.Holder
+	reply = decideSubmission(1, true, "Alex", "", "Jimmy")
+	if !strings.Contains(reply, "Wrote 10.01 by Jimmy") || submissions[0].Status != statusApproved || len(fake.updates) != 1 {
+		t.Errorf("retrying replied %q, left it %s and sent %d updates", reply, submissions[0].Status, len(fake.updates))
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Reply for a full game query that can't be understood
+const fullGameUsage = "Usage: !fg <game> <category> [count] (ex: !fg smb2 story any%, !fg smb1 expert no-warp 5)"
+
+// Title of the optional tab full game runs are listed in, and the cells to
+// fetch from it: game, category, runner, time (linked to the video) and date
+// on every row after the header
+const (
+	fullGameTab   = "Full Game"
+	fullGameRange = "'Full Game'!A2:E"
+)
+
+// How many runs are shown by default, and at most
+const (
+	defaultFullGameRuns = 10
+	maxFullGameRuns     = 25
+)
+
+// fullGameRun is one run on a full game leaderboard
+type fullGameRun struct {
+	Rank   int    `json:"rank,omitempty"`
+	Runner string `json:"runner"`
+	Time   string `json:"time"`
+	Video  string `json:"video,omitempty"`
+	Date   string `json:"date,omitempty"`
+}
+
+// fullGameBoard is the leaderboard of one full game category (ex: SMB2 Story
+// Any%), fastest run first
+type fullGameBoard struct {
+	Game     string        `json:"game"`
+	Category string        `json:"category"`
+	Runs     []fullGameRun `json:"runs"`
+}
+
+// Every full game leaderboard, keyed by fullGameKey. Boards from the sheet
+// replace the ones from fullGameFile.
+var fullGameBoards = make(map[string]*fullGameBoard)
+var fileGameBoards = make(map[string]*fullGameBoard)
+
+// fullGameKey returns the key of a game's category in fullGameBoards, so
+// "Any%", "any" and "ANY %" are the same category
+func fullGameKey(game string, category string) string {
+	return game + "|" + categorySlug(category)
+}
+
+// categorySlug keeps only the letters and digits of a category name, in lower case
+func categorySlug(category string) string {
+	slug := ""
+	for _, r := range strings.ToLower(category) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug += string(r)
+		}
+	}
+	return slug
+}
+
+// parseRunTime converts a full game time (ex: 1:02:03.45, 45:12.5 or 59.99)
+// into seconds
+func parseRunTime(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	seconds := 0.0
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || number < 0 || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		seconds = seconds*60 + number
+	}
+	return seconds, nil
+}
+
+// rankRuns sorts a board's runs fastest first and ranks them. Tied runs share
+// a rank and the next run skips the ranks they took up (1, 2, 2, 4).
+func rankRuns(board *fullGameBoard) {
+	sort.SliceStable(board.Runs, func(i, j int) bool {
+		a, _ := parseRunTime(board.Runs[i].Time)
+		b, _ := parseRunTime(board.Runs[j].Time)
+		return a < b
+	})
+	assignRanks(len(board.Runs), func(i int) float64 {
+		seconds, _ := parseRunTime(board.Runs[i].Time)
+		return seconds
+	}, func(i int, rank int) {
+		board.Runs[i].Rank = rank
+	})
+}
+
+// addRun puts a run on its board in boards, creating the board if needed
+func addRun(boards map[string]*fullGameBoard, game string, category string, run fullGameRun) {
+	key := fullGameKey(game, category)
+	if boards[key] == nil {
+		boards[key] = &fullGameBoard{Game: game, Category: category}
+	}
+	boards[key].Runs = append(boards[key].Runs, run)
+}
+
+// parseFullGameTab reads the runs in the Full Game tab. Rows that can't be
+// read are skipped and their problems returned.
+func parseFullGameTab(sheet *sheets.Sheet) (map[string]*fullGameBoard, []sheetIssue) {
+	boards := make(map[string]*fullGameBoard)
+	var issues []sheetIssue
+	issue := func(row int, col int, format string, args ...interface{}) {
+		issues = append(issues, sheetIssue{Tab: fullGameTab, Section: "FullGame", Row: row, Column: col, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for _, data := range sheet.Data {
+		for i := range data.RowData {
+			// Skip the header in case the whole tab was fetched
+			row := int(data.StartRow) + i
+			if row == 0 {
+				continue
+			}
+			gameName := cellText(data.RowData, i, 0)
+			category := cellText(data.RowData, i, 1)
+			runner := cellText(data.RowData, i, 2)
+			runTime := cellText(data.RowData, i, 3)
+			if gameName == "" && category == "" && runner == "" && runTime == "" {
+				continue
+			}
+
+			game := gameFromName(gameName)
+			if game == "" {
+				issue(row, 0, "unknown game %q", gameName)
+				continue
+			}
+			if category == "" || runner == "" {
+				issue(row, -1, "run needs a category and a runner")
+				continue
+			}
+			if _, err := parseRunTime(runTime); err != nil {
+				issue(row, 3, "expected a time, found %q", runTime)
+				continue
+			}
+			video := ""
+			if cell := cellAt(data.RowData, i, 3); cell != nil {
+				video = cell.Hyperlink
+			}
+			addRun(boards, game, category, fullGameRun{Runner: runner, Time: runTime, Video: video, Date: cellText(data.RowData, i, 4)})
+		}
+	}
+	for _, board := range boards {
+		rankRuns(board)
+	}
+	return boards, issues
+}
+
+// loadFullGameFile reads full game leaderboards from fullGameFile, a JSON
+// list of boards. Nothing is loaded if the flag isn't set.
+func loadFullGameFile() error {
+	if *fullGameFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*fullGameFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []fullGameBoard
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	boards := make(map[string]*fullGameBoard)
+	for _, board := range list {
+		game := gameFromName(board.Game)
+		if game == "" {
+			return fmt.Errorf("%s: unknown game %q", *fullGameFile, board.Game)
+		}
+		for _, run := range board.Runs {
+			if _, err := parseRunTime(run.Time); err != nil {
+				return fmt.Errorf("%s: %s %s: %v", *fullGameFile, board.Game, board.Category, err)
+			}
+			addRun(boards, game, board.Category, run)
+		}
+	}
+	for _, board := range boards {
+		rankRuns(board)
+	}
+	fileGameBoards = boards
+	setFullGameBoards(nil)
+	return nil
+}
+
+// setFullGameBoards makes the boards from the sheet, along with the ones
+// from the file the sheet doesn't have, the current leaderboards
+func setFullGameBoards(sheetBoards map[string]*fullGameBoard) {
+	boards := make(map[string]*fullGameBoard)
+	for key, board := range fileGameBoards {
+		boards[key] = board
+	}
+	for key, board := range sheetBoards {
+		boards[key] = board
+	}
+	fullGameBoards = boards
+}
+
+// gameBoards returns a game's leaderboards sorted by category
+func gameBoards(game string) []*fullGameBoard {
+	var boards []*fullGameBoard
+	for _, board := range fullGameBoards {
+		if board.Game == game {
+			boards = append(boards, board)
+		}
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].Category < boards[j].Category
+	})
+	return boards
+}
+
+// buildFullGameReply handles !fg, showing the top runs of a full game category
+func buildFullGameReply(message string, games []string) string {
+	fields := strings.Fields(message)[1:]
+	if len(fields) == 0 {
+		return fullGameUsage
+	}
+	game := gameFromName(fields[0])
+	if game == "" || !hasGame(games, game) {
+		return fullGameUsage
+	}
+	fields = fields[1:]
+
+	// List the categories if none was asked for
+	boards := gameBoards(game)
+	if len(fields) == 0 {
+		if len(boards) == 0 {
+			return "There are no full game leaderboards for " + gameDisplayName(game)
+		}
+		var categories []string
+		for _, board := range boards {
+			categories = append(categories, board.Category)
+		}
+		return gameDisplayName(game) + " full game categories: " + strings.Join(categories, ", ")
+	}
+
+	// The whole rest is the category if there is one by that name (ex:
+	// "Deluxe 100"), otherwise a number at the end is how many runs to show
+	count := defaultFullGameRuns
+	board := fullGameBoards[fullGameKey(game, strings.Join(fields, " "))]
+	if board == nil && len(fields) > 1 {
+		if n, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			count = n
+			fields = fields[:len(fields)-1]
+			board = fullGameBoards[fullGameKey(game, strings.Join(fields, " "))]
+		}
+	}
+	if board == nil {
+		var categories []string
+		for _, board := range boards {
+			categories = append(categories, board.Category)
+		}
+		if len(categories) == 0 {
+			return "There are no full game leaderboards for " + gameDisplayName(game)
+		}
+		return gameDisplayName(game) + " has no category " + strings.Join(fields, " ") + " (categories: " + strings.Join(categories, ", ") + ")"
+	}
+	if count < 1 || count > maxFullGameRuns {
+		return "You can show 1 to " + strconv.Itoa(maxFullGameRuns) + " runs"
+	}
+
+	// Runs tied for the last place shown are all included
+	shown := board.Runs[:rankedCount(len(board.Runs), func(i int) int { return board.Runs[i].Rank }, count)]
+	returnMessage := gameDisplayName(game) + " " + board.Category + " (top " + strconv.Itoa(len(shown)) + " of " + strconv.Itoa(len(board.Runs)) + ")\n"
+	for _, run := range shown {
+		returnMessage += strconv.Itoa(run.Rank) + ". " + run.Time + " by " + run.Runner
+		if run.Date != "" {
+			returnMessage += " on " + run.Date
+		}
+		if run.Video != "" {
+			returnMessage += " (" + formatVideo(run.Video) + ")"
+		}
+		returnMessage += "\n"
+	}
+	return returnMessage
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestBoards loads the full game leaderboards from the fixture file and
+// the Full Game tab of the fixture spreadsheet
+func loadTestBoards(t *testing.T) {
+	t.Helper()
+	setFlag(t, fullGameFile, filepath.Join("testdata", "fullgame.json"))
+	if err := loadFullGameFile(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fileGameBoards = make(map[string]*fullGameBoard)
+		setFullGameBoards(nil)
+	})
+	boards, issues := parseFullGameTab(findSheet(loadFixture(t, "spreadsheet.json"), fullGameTab))
+	if len(issues) != 0 {
+		t.Fatalf("unexpected issues parsing the fixture:\n%s", formatIssues("", issues))
+	}
+	setFullGameBoards(boards)
+}
+
+func TestFullGameCount(t *testing.T) {
+	loadTestBoards(t)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// A number that is part of the category isn't a count
+		{"!fg smbdx deluxe 100", "SMBDX Deluxe 100 (top 2 of 2)"},
+		{"!fg smbdx deluxe 100 1", "SMBDX Deluxe 100 (top 1 of 2)"},
+		// Runs tied for the last place shown are all included
+		{"!fg smb2 story any% 2", "SMB2 Story Any% (top 3 of 4)"},
+		{"!fg smb2 story any% 1", "SMB2 Story Any% (top 1 of 4)"},
+		{"!fg smb2 story any%", "SMB2 Story Any% (top 4 of 4)"},
+		{"!fg smb2 story any% 0", "You can show 1 to 25 runs"},
+		{"!fg smb2 story any% 26", "You can show 1 to 25 runs"},
+		{"!fg smbdx deluxe 99", "SMBDX has no category deluxe (categories: Deluxe 100)"},
+	}
+	for _, test := range tests {
+		reply := buildReply(test.query, allGames)
+		if got := strings.SplitN(reply, "\n", 2)[0]; got != test.want {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseRunTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		seconds float64
+		ok      bool
+	}{
+		{"59.99", 59.99, true},
+		{"45:12.5", 45*60 + 12.5, true},
+		{"1:02:03.45", 3600 + 2*60 + 3.45, true},
+		{"4:10:00", 4*3600 + 10*60, true},
+		{"1.5:00", 0, false},
+		{"1:2:3:4", 0, false},
+		{"fast", 0, false},
+		{"-1:00", 0, false},
+	}
+	for _, test := range tests {
+		seconds, err := parseRunTime(test.value)
+		if (err == nil) != test.ok || seconds != test.seconds {
+			t.Errorf("parseRunTime(%q) = %v, %v; want %v, ok %t", test.value, seconds, err, test.seconds, test.ok)
+		}
+	}
+}
+
+func TestFullGameTiesShareARank(t *testing.T) {
+	loadTestBoards(t)
+
+	board := fullGameBoards[fullGameKey("SMB2", "story any %")]
+	if board == nil {
+		t.Fatal("no SMB2 Story Any% board")
+	}
+	want := []struct {
+		rank   int
+		runner string
+	}{{1, "bobjrsenior"}, {2, "Alex"}, {2, "CyclopsDragon"}, {4, "Nambo"}}
+	if len(board.Runs) != len(want) {
+		t.Fatalf("got %d runs, want %d", len(board.Runs), len(want))
+	}
+	for i, run := range board.Runs {
+		if run.Rank != want[i].rank || run.Runner != want[i].runner {
+			t.Errorf("run %d: got %d. %s, want %d. %s", i, run.Rank, run.Runner, want[i].rank, want[i].runner)
+		}
+	}
+}
+
+func TestSheetBoardsReplaceFileBoards(t *testing.T) {
+	loadTestBoards(t)
+
+	// The file's SMB2 Story Any% only has "Replaced" in it
+	for _, run := range fullGameBoards[fullGameKey("SMB2", "Story Any%")].Runs {
+		if run.Runner == "Replaced" {
+			t.Error("the file's board wasn't replaced by the sheet's")
+		}
+	}
+	if fullGameBoards[fullGameKey("SMBD", "Deluxe 100")] == nil {
+		t.Error("the file's board the sheet doesn't have is missing")
+	}
+}
+
+func TestFullGameReplies(t *testing.T) {
+	loadTestBoards(t)
+
+	tests := []struct {
+		query string
+		games []string
+		want  string
+	}{
+		{"!fg", allGames, fullGameUsage},
+		{"!fg smb3", allGames, fullGameUsage},
+		{"!fg smb1 expert no-warp", []string{"SMB2"}, fullGameUsage},
+		{"!fg smb2", allGames, "SMB2 full game categories: Story Any%"},
+		{"!fg bm", allGames, "Banana Mania full game categories: Challenge Any%"},
+		{"!fg smb2 all levels", allGames, "SMB2 has no category all levels (categories: Story Any%)"},
+		{"!fg SMB2 STORY ANY % 2", allGames, "SMB2 Story Any% (top 3 of 4)\n" +
+			"1. 58:59.99 by bobjrsenior on 2020-02-01 (Twitch: <https://www.twitch.tv/videos/111>)\n" +
+			"2. 1:02:03.45 by Alex on 2020-03-01 (YouTube: <https://youtu.be/Xq3fGany1aB>)\n" +
+			"2. 1:02:03.45 by CyclopsDragon\n"},
+		{"!fg smb1 expert no-warp 1", allGames, "SMB1 Expert No-Warp (top 1 of 2)\n" +
+			"1. 12:34.56 by Alex on 2021-05-05 (YouTube: <https://youtu.be/Pz2fGexp9eF>)\n"},
+	}
+	for _, test := range tests {
+		if got := buildReply(test.query, test.games); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestFullGameTabIssues(t *testing.T) {
+	_, issues := parseFullGameTab(findSheet(loadFixture(t, "broken.json"), fullGameTab))
+	want := []string{
+		`'Full Game'!A3 (FullGame): unknown game "SMB3"`,
+		`'Full Game' row 4 (FullGame): run needs a category and a runner`,
+		`'Full Game'!D5 (FullGame): expected a time, found "fast"`,
+		`'Full Game'!D6 (FullGame): expected a time, found "1:2:3:4"`,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues, want %d:\n%s", len(issues), len(want), formatIssues("", issues))
+	}
+	for i, issue := range issues {
+		if issue.String() != want[i] {
+			t.Errorf("issue %d: got %q, want %q", i, issue.String(), want[i])
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// challengeDifficulty is a challenge mode difficulty along with the letter
+// used for it in stage codes (ex: "e" in "!e10"). Codes are a single letter.
+type challengeDifficulty struct {
+	Name string
+	Code string
+}
+
+// The challenge mode difficulties of SMB1, SMB2, SMBDX and Banana Mania, in
+// order
+var standardDifficulties = []challengeDifficulty{
+	{Name: "Beginner", Code: "b"},
+	{Name: "Advanced", Code: "a"},
+	{Name: "Expert", Code: "e"},
+	{Name: "Master", Code: "m"},
+}
+
+// gameVariant is a named variant of a game's records, such as a mode with
+// its own records for the same stages (ex: Banana Mania's reverse mode)
+type gameVariant struct {
+	// Used in map keys between the category and the score type (ex: "Reverse")
+	Key string
+	// The name used in replies (ex: "Reverse")
+	Name string
+	// Lower case names users can ask for the variant by, which may be more
+	// than one word (ex: "golden banana")
+	Aliases []string
+}
+
+// gameInfo describes a game the bot has records for. It declares the game's
+// difficulties and story worlds, which stage codes are read with, while extra
+// stages are found from the sections of its tabs. A game with no tabs yet is
+// known by name but has no records.
+type gameInfo struct {
+	// Used at the start of map keys (ex: "SMBD")
+	Key string
+	// The name used in replies (ex: "SMBDX")
+	Name string
+	// Lower case names users can ask for the game by
+	Aliases []string
+	// Challenge mode difficulties, in order, used for stage codes like "e10"
+	Difficulties []challengeDifficulty
+	// How many story worlds the game has, used for stage codes like "s3-7"
+	Worlds int
+	// Variants with their own records, asked for after the stage
+	// (ex: !bm e10 reverse)
+	Variants []gameVariant
+	// Where the game's records are in the IL spreadsheet
+	Tabs []tabLayout
+	// The game's abbreviation on speedrun.com, "" if it isn't imported
+	Speedrun string
+}
+
+// gameRegistry is every game the bot knows about, in the order they are shown
+var gameRegistry = []gameInfo{
+	{
+		Key:          "SMB1",
+		Name:         "SMB1",
+		Aliases:      []string{"smb1", "smb"},
+		Difficulties: standardDifficulties,
+		Tabs:         smb1Tabs,
+		Speedrun:     "smb1",
+	},
+	{
+		Key:          "SMB2",
+		Name:         "SMB2",
+		Aliases:      []string{"smb2"},
+		Difficulties: standardDifficulties,
+		Worlds:       10,
+		Tabs:         smb2Tabs,
+		Speedrun:     "smb2",
+	},
+	{
+		Key:          "SMBD",
+		Name:         "SMBDX",
+		Aliases:      []string{"smbd", "smbdx", "deluxe"},
+		Difficulties: standardDifficulties,
+		Worlds:       10,
+		Tabs:         smbdTabs,
+		Speedrun:     "smbdx",
+	},
+	{
+		Key:          "BM",
+		Name:         "Banana Mania",
+		Aliases:      []string{"bm", "mania", "bananamania"},
+		Difficulties: standardDifficulties,
+		Variants: []gameVariant{
+			{Key: "SMB2", Name: "SMB2", Aliases: []string{"smb2"}},
+			{Key: "Deluxe", Name: "Deluxe", Aliases: []string{"deluxe", "dx", "smbdx"}},
+			{Key: "Reverse", Name: "Reverse", Aliases: []string{"reverse", "rev"}},
+			{Key: "Golden", Name: "Golden Banana", Aliases: []string{"golden banana", "golden", "gb"}},
+			{Key: "Dark", Name: "Dark Banana", Aliases: []string{"dark banana", "dark", "db"}},
+		},
+		Tabs:     bananaManiaTabs,
+		Speedrun: "smbbm",
+	},
+	// The IL sheet has no tabs for these yet. Their tabs go here once it
+	// does, laid out like the other games' tabs.
+	{
+		Key:     "BB",
+		Name:    "Banana Blitz",
+		Aliases: []string{"bb", "blitz", "bananablitz"},
+		Worlds:  8,
+	},
+	{
+		Key:     "BBHD",
+		Name:    "Banana Blitz HD",
+		Aliases: []string{"bbhd", "blitzhd", "bananablitzhd"},
+		Worlds:  8,
+	},
+	{
+		Key:     "SMBA",
+		Name:    "SMB Adventure",
+		Aliases: []string{"smba", "adventure"},
+	},
+	{
+		Key:     "SMBSR",
+		Name:    "SMB Step & Roll",
+		Aliases: []string{"smbsr", "stepandroll", "step&roll"},
+	},
+}
+
+// registryGames returns the key of every registered game
+func registryGames() []string {
+	var games []string
+	for _, game := range gameRegistry {
+		games = append(games, game.Key)
+	}
+	return games
+}
+
+// registryLayout returns the tabs of every registered game
+func registryLayout() []tabLayout {
+	var tabs []tabLayout
+	for _, game := range gameRegistry {
+		tabs = append(tabs, game.Tabs...)
+	}
+	return tabs
+}
+
+// findGame returns the registered game with the given key, or nil
+func findGame(key string) *gameInfo {
+	for i := range gameRegistry {
+		if gameRegistry[i].Key == key {
+			return &gameRegistry[i]
+		}
+	}
+	return nil
+}
+
+// matchVariant finds the variant of a game named at the start of args (ex:
+// "golden banana"), returning its key and how many arguments name it. No
+// match means the game's main records.
+func matchVariant(game string, args []string) (variant string, used int) {
+	info := findGame(game)
+	if info == nil {
+		return "", 0
+	}
+	for n := len(args); n > 0; n-- {
+		name := strings.ToLower(strings.Join(args[:n], " "))
+		for _, v := range info.Variants {
+			for _, alias := range v.Aliases {
+				if name == alias {
+					return v.Key, n
+				}
+			}
+		}
+	}
+	return "", 0
+}
+
+// splitVariant splits the variant off the end of a category (ex:
+// "ExpertReverse" gives "Expert" and the reverse variant)
+func splitVariant(game string, category string) (string, *gameVariant) {
+	if info := findGame(game); info != nil {
+		for i, v := range info.Variants {
+			if strings.HasSuffix(category, v.Key) && category != v.Key {
+				return strings.TrimSuffix(category, v.Key), &info.Variants[i]
+			}
+		}
+	}
+	return category, nil
+}
+
+// findDifficulty returns the difficulty any registered game has with the
+// given code or name, or nil
+func findDifficulty(name string) *challengeDifficulty {
+	for _, game := range gameRegistry {
+		for i, difficulty := range game.Difficulties {
+			if name == difficulty.Code || name == strings.ToLower(difficulty.Name) {
+				return &game.Difficulties[i]
+			}
+		}
+	}
+	return nil
+}
+
+// difficultyCodes returns the code of every registered game's difficulties
+// (ex: "baem")
+func difficultyCodes() string {
+	codes := ""
+	for _, game := range gameRegistry {
+		for _, difficulty := range game.Difficulties {
+			if !strings.Contains(codes, difficulty.Code) {
+				codes += difficulty.Code
+			}
+		}
+	}
+	return codes
+}
+
+// storyWorlds returns the number of every story world a game has
+func storyWorlds(game string) []string {
+	var worlds []string
+	if info := findGame(game); info != nil {
+		for world := 1; world <= info.Worlds; world++ {
+			worlds = append(worlds, strconv.Itoa(world))
+		}
+	}
+	return worlds
+}
+
+// hasSection reports whether a game's main records have a category
+func hasSection(game string, category string) bool {
+	for _, sec := range recordSections() {
+		if sec.Game == game && sec.Variant == "" && sec.Category() == category {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGamesReply handles !games, listing every game with the names it can be
+// asked for by and the modes its tabs have records for
+func buildGamesReply(games []string) string {
+	returnMessage := ""
+	for _, key := range games {
+		game := findGame(key)
+		if game == nil {
+			continue
+		}
+		var modes []string
+		var difficulties []string
+		extras := false
+		for _, difficulty := range game.Difficulties {
+			if hasSection(key, difficulty.Name) {
+				difficulties = append(difficulties, difficulty.Name)
+			}
+			extras = extras || hasSection(key, difficulty.Name+"Extra")
+		}
+		if len(difficulties) > 0 {
+			challenge := strings.Join(difficulties, ", ")
+			if extras {
+				challenge += " (with extras)"
+			}
+			modes = append(modes, challenge)
+		}
+		if worlds := storyWorlds(key); len(worlds) > 0 {
+			modes = append(modes, "story worlds "+worlds[0]+"-"+worlds[len(worlds)-1])
+		}
+		if len(game.Tabs) == 0 {
+			modes = append(modes, "no records in the sheet yet")
+		}
+		if len(game.Variants) > 0 {
+			var variants []string
+			for _, v := range game.Variants {
+				variants = append(variants, v.Aliases[0])
+			}
+			modes = append(modes, "variants "+strings.Join(variants, ", "))
+		}
+		returnMessage += game.Name + " (" + strings.Join(game.Aliases, ", ") + "): " + strings.Join(modes, "; ") + "\n"
+	}
+	return returnMessage
+}
+
+// Reply for a game's stage query that can't be understood
+const gameStageUsage = "Usage: !<game> <stage> [variant] (ex: !bm e10 reverse or !smb2 s3-7)"
+
+// isGameQuery reports whether the message asks for a stage of one game by
+// starting with the game's name (ex: "!bm e10 reverse")
+func isGameQuery(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && strings.HasPrefix(fields[0], "!") && gameFromName(fields[0][1:]) != ""
+}
+
+// buildGameStageReply answers a query for one game's stage, which may be in
+// one of the game's variants
+func buildGameStageReply(message string, games []string) string {
+	args := strings.Fields(message)
+	args[0] = strings.TrimPrefix(args[0], "!")
+	stage, rest, problem := parseStage(args, games)
+	if problem != "" {
+		return problem + "\n" + gameStageUsage
+	}
+	if len(rest) != 0 {
+		info := findGame(stage.Game)
+		if len(info.Variants) == 0 {
+			return gameStageUsage
+		}
+		var variants []string
+		for _, v := range info.Variants {
+			variants = append(variants, v.Aliases[0])
+		}
+		return strings.Join(rest, " ") + " isn't a variant of " + info.Name + " (variants: " + strings.Join(variants, ", ") + ")"
+	}
+
+	name := gameDisplayName(stage.Game)
+	if _, variant := splitVariant(stage.Game, stage.Category); variant != nil {
+		name += " " + variant.Name
+	}
+	name += " " + getLevelName(stage.Game, stage.Category, "Time", stage.Level)
+	stageTime := retrieveRecordString(stage.Game, stage.Category, "Time", stage.Level)
+	stageScore := retrieveRecordString(stage.Game, stage.Category, "Score", stage.Level)
+	return name + ": " + stageTime + ", " + stageScore + "\n"
+}
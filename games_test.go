@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Every section of a game's tabs has to be one of the modes the game declares,
+// or its stages couldn't be asked for
+func TestRegistryMatchesLayout(t *testing.T) {
+	for _, sec := range recordSections() {
+		game := findGame(sec.Game)
+		category, _ := splitVariant(sec.Game, sec.Category())
+		if strings.HasPrefix(category, "Story") {
+			world, err := strconv.Atoi(strings.TrimPrefix(category, "Story"))
+			if err != nil || world < 1 || world > game.Worlds {
+				t.Errorf("%s isn't one of %s's %d worlds", sec.Key, game.Name, game.Worlds)
+			}
+			continue
+		}
+		declared := false
+		for _, difficulty := range game.Difficulties {
+			declared = declared || strings.TrimSuffix(category, "Extra") == difficulty.Name
+		}
+		if !declared {
+			t.Errorf("%s isn't one of %s's difficulties", sec.Key, game.Name)
+		}
+	}
+}
+
+func TestGameWithoutTabs(t *testing.T) {
+	loadFixtureRecords(t)
+
+	if got, want := buildReply("!bb s1-1", registryGames()), "The sheet has no Banana Blitz records yet\n"+gameStageUsage; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := stageCode("SMB2", "ExpertExtra", 3), "ex3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Golden tests cover parsing the sheet and the replies to queries. Features
+// built on top of them are tested next to the feature instead.
+//
+// Run "go test -run Golden -update" to rewrite the golden files after an
+// intended change to the output
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// checkGolden compares output with testdata/golden/<name>.golden
+func checkGolden(t *testing.T, name string, output string) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, []byte(output), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if output == string(want) {
+		return
+	}
+	gotLines := strings.Split(output, "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		got, expected := "", ""
+		if i < len(gotLines) {
+			got = gotLines[i]
+		}
+		if i < len(wantLines) {
+			expected = wantLines[i]
+		}
+		if got != expected {
+			t.Fatalf("%s differs at line %d:\n got: %q\nwant: %q\n(run go test -update if this is intended)", path, i+1, got, expected)
+		}
+	}
+}
+
+func TestGoldenParseSection(t *testing.T) {
+	spreadsheet := loadFixture(t, "spreadsheet.json")
+
+	output := ""
+	for _, tab := range sheetLayout {
+		sheet := findSheet(spreadsheet, tab.Title)
+		if sheet == nil {
+			continue
+		}
+		rowData := sheet.Data[0].RowData
+		for _, sec := range tab.Sections {
+			store := make(map[string][]Record)
+			issues := parseSection(store, rowData, tab.Title, sec)
+			output += fmt.Sprintf("== %s (%s)\n", sec.Key, tab.Title)
+			for _, issue := range issues {
+				output += "issue: " + issue.String() + "\n"
+			}
+			for _, record := range store[sec.Key] {
+				output += fmt.Sprintf("%d\t%s\t%s\t%q\t%q\t%q\t%t\n", record.Index, record.Game, record.Name, record.Time, record.Holder, record.Video, record.IsTime)
+			}
+		}
+	}
+	checkGolden(t, "parse", output)
+}
+
+func TestGoldenSheetIssues(t *testing.T) {
+	// The headers of the good sheet are the ones the broken sheet is checked against
+	knownHeaders = make(map[string][]string)
+	rememberHeaders(loadFixture(t, "spreadsheet.json"), nil)
+	defer func() { knownHeaders = make(map[string][]string) }()
+
+	broken := loadFixture(t, "broken.json")
+	output := ""
+	for _, issue := range validateSpreadsheet(broken) {
+		output += "validate: " + issue.String() + "\n"
+	}
+
+	// Parse without skipping anything so the parser's own checks are covered too
+	store, issues := parseSpreadsheet(broken, nil)
+	for _, issue := range issues {
+		output += "parse: " + issue.String() + "\n"
+	}
+	var parsed []string
+	for _, sec := range recordSections() {
+		if _, ok := store[sec.Key]; ok {
+			parsed = append(parsed, sec.Key)
+		}
+	}
+	output += "parsed: " + strings.Join(parsed, ", ") + "\n"
+	checkGolden(t, "issues", output)
+}
+
+func TestGoldenRecordStrings(t *testing.T) {
+	loadFixtureRecords(t)
+
+	output := ""
+	for _, game := range allGames {
+		for _, sec := range recordSections() {
+			category := sec.Category()
+			if sec.Game != game || !sec.IsTime || strings.HasPrefix(category, "Story") || records[sec.Key] == nil {
+				continue
+			}
+			// One past each end to cover the bounds checks
+			for level := 0; level <= sec.Amount+1; level++ {
+				output += fmt.Sprintf("%s %s %d %s | %s | %s\n", game, category, level,
+					getLevelName(game, category, "Time", level),
+					retrieveRecordString(game, category, "Time", level),
+					retrieveRecordString(game, category, "Score", level))
+			}
+		}
+	}
+	output += "missing: " + retrieveRecordString("SMB1", "Nonexistent", "Time", 1) + getLevelName("SMB1", "Nonexistent", "Time", 1) + "\n"
+	checkGolden(t, "records", output)
+}
+
+func TestGoldenStoryStrings(t *testing.T) {
+	loadFixtureRecords(t)
+
+	output := ""
+	for _, game := range allGames {
+		for _, world := range storyWorlds(game) {
+			worldNumber, _ := strconv.Atoi(world)
+			for level := 0; level <= storyFloorCount(game, worldNumber)+1; level++ {
+				output += fmt.Sprintf("%s %s-%d %s | %s | %s\n", game, world, level,
+					getStoryLevelName(game, "Story", "Time", world, level),
+					retrieveRecordStoryString(game, "Story", "Time", world, level),
+					retrieveRecordStoryString(game, "Story", "Score", world, level))
+			}
+		}
+	}
+	output += "missing: " + retrieveRecordStoryString("SMB1", "Story", "Time", "1", 1) + getStoryLevelName("SMB1", "Story", "Time", "1", 1) + "\n"
+	checkGolden(t, "story", output)
+}
+
+func TestGoldenReplies(t *testing.T) {
+	loadFixtureRecords(t)
+
+	// Every difficulty, with and without extras, plus the edges of each
+	var queries []string
+	for _, difficulty := range []string{"b", "a", "e", "m"} {
+		for _, extra := range []string{"", "x"} {
+			for _, level := range []string{"0", "1", "7", "10", "11", "30", "50", "100", "121"} {
+				queries = append(queries, "!"+difficulty+extra+level)
+			}
+		}
+	}
+	queries = append(queries,
+		"!s1-1", "!s 3 7", "!w3f9", "!story 10 10", "!s10-20", "!s11-1", "!s3-0", "!s",
+		"!world smb2 3", "!world smbdx 10", "!world smb1 1",
+		"!bx", "!b", "!x1", "!b1x", "!games", "hello",
+		"!bm e10", "!bm e10 reverse", "!BM ex3 Rev", "!bm e10 golden banana", "!bm e10 dark", "!bm", "!bm e10 backwards",
+		"!smb2 s3-7", "!smbdx b40", "!smb1 b11", "!mania mx1")
+
+	output := ""
+	for _, query := range queries {
+		output += "> " + query + "\n" + buildReply(query, allGames) + "\n"
+		output += "> " + query + " (SMB2 only)\n" + buildReply(query, []string{"SMB2"}) + "\n"
+	}
+	checkGolden(t, "replies", output)
+}
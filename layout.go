@@ -0,0 +1,384 @@
+package main
+
+import (
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// section describes where one category of records lives in a sheet tab. Each
+// row of a section has the stage name in StartCol, the time or score (with the
+// video as its hyperlink) in the next column and the holder in the one after.
+// StartRow and StartCol are zero based and the row above StartRow is the
+// section's header. Sections of a variant (ex: Banana Mania's reverse mode)
+// have the variant's key between the category and the score type in Key.
+type section struct {
+	Key      string
+	Game     string
+	StartRow int
+	StartCol int
+	Amount   int
+	IsTime   bool
+	Variant  string
+}
+
+// tabLayout lists the sections found in one tab of the IL spreadsheet.
+// Optional tabs may not have been added to the sheet yet and are skipped
+// quietly when they are missing.
+type tabLayout struct {
+	Title    string
+	Sections []section
+	Optional bool
+}
+
+// Where each game's records are in the IL spreadsheet
+var smb1Tabs = []tabLayout{
+	{
+		Title: "SMB1 Time",
+		Sections: []section{
+			{Key: "SMB1BeginnerTime", Game: "SMB1", StartRow: 3, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB1BeginnerExtraTime", Game: "SMB1", StartRow: 18, StartCol: 2, Amount: 3, IsTime: true},
+			{Key: "SMB1BeginnerTimeAlt", Game: "SMB1", StartRow: 7, StartCol: 22, Amount: 1, IsTime: true},
+
+			{Key: "SMB1AdvancedTime", Game: "SMB1", StartRow: 3, StartCol: 7, Amount: 30, IsTime: true},
+			{Key: "SMB1AdvancedExtraTime", Game: "SMB1", StartRow: 38, StartCol: 7, Amount: 5, IsTime: true},
+			{Key: "SMB1AdvancedTimeAlt", Game: "SMB1", StartRow: 13, StartCol: 22, Amount: 5, IsTime: true},
+
+			{Key: "SMB1ExpertTime", Game: "SMB1", StartRow: 3, StartCol: 12, Amount: 50, IsTime: true},
+			{Key: "SMB1ExpertExtraTime", Game: "SMB1", StartRow: 58, StartCol: 12, Amount: 10, IsTime: true},
+			{Key: "SMB1ExpertTimeAlt", Game: "SMB1", StartRow: 24, StartCol: 22, Amount: 5, IsTime: true},
+
+			{Key: "SMB1MasterTime", Game: "SMB1", StartRow: 3, StartCol: 17, Amount: 10, IsTime: true},
+		},
+	},
+	{
+		Title: "SMB1 Score",
+		Sections: []section{
+			{Key: "SMB1BeginnerScore", Game: "SMB1", StartRow: 3, StartCol: 2, Amount: 10, IsTime: false},
+			{Key: "SMB1BeginnerExtraScore", Game: "SMB1", StartRow: 18, StartCol: 2, Amount: 3, IsTime: false},
+
+			{Key: "SMB1AdvancedScore", Game: "SMB1", StartRow: 3, StartCol: 7, Amount: 30, IsTime: false},
+			{Key: "SMB1AdvancedExtraScore", Game: "SMB1", StartRow: 38, StartCol: 7, Amount: 5, IsTime: false},
+
+			{Key: "SMB1ExpertScore", Game: "SMB1", StartRow: 3, StartCol: 12, Amount: 50, IsTime: false},
+			{Key: "SMB1ExpertExtraScore", Game: "SMB1", StartRow: 58, StartCol: 12, Amount: 10, IsTime: false},
+
+			{Key: "SMB1MasterScore", Game: "SMB1", StartRow: 3, StartCol: 17, Amount: 10, IsTime: false},
+		},
+	},
+}
+
+var smb2Tabs = []tabLayout{
+	// Finish Alts
+	{
+		Title: "SMB2 Challenge Time",
+		Sections: []section{
+			{Key: "SMB2BeginnerTime", Game: "SMB2", StartRow: 3, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB2BeginnerExtraTime", Game: "SMB2", StartRow: 18, StartCol: 2, Amount: 10, IsTime: true},
+
+			{Key: "SMB2AdvancedTime", Game: "SMB2", StartRow: 3, StartCol: 7, Amount: 30, IsTime: true},
+			{Key: "SMB2AdvancedExtraTime", Game: "SMB2", StartRow: 38, StartCol: 7, Amount: 10, IsTime: true},
+
+			{Key: "SMB2ExpertTime", Game: "SMB2", StartRow: 3, StartCol: 12, Amount: 50, IsTime: true},
+			{Key: "SMB2ExpertExtraTime", Game: "SMB2", StartRow: 58, StartCol: 12, Amount: 10, IsTime: true},
+
+			{Key: "SMB2MasterTime", Game: "SMB2", StartRow: 3, StartCol: 17, Amount: 10, IsTime: true},
+			{Key: "SMB2MasterExtraTime", Game: "SMB2", StartRow: 18, StartCol: 17, Amount: 10, IsTime: true},
+		},
+	},
+	{
+		Title: "SMB2 Challenge Score",
+		Sections: []section{
+			{Key: "SMB2BeginnerScore", Game: "SMB2", StartRow: 3, StartCol: 2, Amount: 10, IsTime: false},
+			{Key: "SMB2BeginnerExtraScore", Game: "SMB2", StartRow: 18, StartCol: 2, Amount: 10, IsTime: false},
+
+			{Key: "SMB2AdvancedScore", Game: "SMB2", StartRow: 3, StartCol: 7, Amount: 30, IsTime: false},
+			{Key: "SMB2AdvancedExtraScore", Game: "SMB2", StartRow: 38, StartCol: 7, Amount: 10, IsTime: false},
+
+			{Key: "SMB2ExpertScore", Game: "SMB2", StartRow: 3, StartCol: 12, Amount: 50, IsTime: false},
+			{Key: "SMB2ExpertExtraScore", Game: "SMB2", StartRow: 58, StartCol: 12, Amount: 10, IsTime: false},
+
+			{Key: "SMB2MasterScore", Game: "SMB2", StartRow: 3, StartCol: 17, Amount: 10, IsTime: false},
+			{Key: "SMB2MasterExtraScore", Game: "SMB2", StartRow: 18, StartCol: 17, Amount: 10, IsTime: false},
+		},
+	},
+	{
+		Title: "SMB2 Story",
+		Sections: []section{
+			{Key: "SMB2Story1Time", Game: "SMB2", StartRow: 3, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB2Story1Score", Game: "SMB2", StartRow: 3, StartCol: 7, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story2Time", Game: "SMB2", StartRow: 3, StartCol: 12, Amount: 10, IsTime: true},
+			{Key: "SMB2Story2Score", Game: "SMB2", StartRow: 3, StartCol: 17, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story3Time", Game: "SMB2", StartRow: 16, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB2Story3Score", Game: "SMB2", StartRow: 16, StartCol: 7, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story4Time", Game: "SMB2", StartRow: 16, StartCol: 12, Amount: 10, IsTime: true},
+			{Key: "SMB2Story4Score", Game: "SMB2", StartRow: 16, StartCol: 17, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story5Time", Game: "SMB2", StartRow: 29, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB2Story5Score", Game: "SMB2", StartRow: 29, StartCol: 7, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story6Time", Game: "SMB2", StartRow: 29, StartCol: 12, Amount: 10, IsTime: true},
+			{Key: "SMB2Story6Score", Game: "SMB2", StartRow: 29, StartCol: 17, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story7Time", Game: "SMB2", StartRow: 42, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB2Story7Score", Game: "SMB2", StartRow: 42, StartCol: 7, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story8Time", Game: "SMB2", StartRow: 42, StartCol: 12, Amount: 10, IsTime: true},
+			{Key: "SMB2Story8Score", Game: "SMB2", StartRow: 42, StartCol: 17, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story9Time", Game: "SMB2", StartRow: 55, StartCol: 2, Amount: 10, IsTime: true},
+			{Key: "SMB2Story9Score", Game: "SMB2", StartRow: 55, StartCol: 7, Amount: 10, IsTime: false},
+
+			{Key: "SMB2Story10Time", Game: "SMB2", StartRow: 55, StartCol: 12, Amount: 10, IsTime: true},
+			{Key: "SMB2Story10Score", Game: "SMB2", StartRow: 55, StartCol: 17, Amount: 10, IsTime: false},
+		},
+	},
+}
+
+var smbdTabs = []tabLayout{
+	// Finish Alts
+	{
+		Title: "SMBDX Challenge Time",
+		Sections: []section{
+			{Key: "SMBDBeginnerTime", Game: "SMBD", StartRow: 3, StartCol: 2, Amount: 40, IsTime: true},
+			{Key: "SMBDBeginnerExtraTime", Game: "SMBD", StartRow: 48, StartCol: 2, Amount: 20, IsTime: true},
+
+			{Key: "SMBDAdvancedTime", Game: "SMBD", StartRow: 3, StartCol: 7, Amount: 70, IsTime: true},
+			{Key: "SMBDAdvancedExtraTime", Game: "SMBD", StartRow: 78, StartCol: 7, Amount: 20, IsTime: true},
+
+			{Key: "SMBDExpertTime", Game: "SMBD", StartRow: 3, StartCol: 12, Amount: 100, IsTime: true},
+			{Key: "SMBDExpertExtraTime", Game: "SMBD", StartRow: 108, StartCol: 12, Amount: 20, IsTime: true},
+
+			{Key: "SMBDMasterTime", Game: "SMBD", StartRow: 3, StartCol: 17, Amount: 12, IsTime: true},
+			{Key: "SMBDMasterExtraTime", Game: "SMBD", StartRow: 28, StartCol: 17, Amount: 10, IsTime: true},
+		},
+	},
+	{
+		Title: "SMBDX Challenge Score",
+		Sections: []section{
+			{Key: "SMBDBeginnerScore", Game: "SMBD", StartRow: 3, StartCol: 2, Amount: 40, IsTime: false},
+			{Key: "SMBDBeginnerExtraScore", Game: "SMBD", StartRow: 48, StartCol: 2, Amount: 20, IsTime: false},
+
+			{Key: "SMBDAdvancedScore", Game: "SMBD", StartRow: 3, StartCol: 7, Amount: 70, IsTime: false},
+			{Key: "SMBDAdvancedExtraScore", Game: "SMBD", StartRow: 78, StartCol: 7, Amount: 20, IsTime: false},
+
+			{Key: "SMBDExpertScore", Game: "SMBD", StartRow: 3, StartCol: 12, Amount: 100, IsTime: false},
+			{Key: "SMBDExpertExtraScore", Game: "SMBD", StartRow: 108, StartCol: 12, Amount: 20, IsTime: false},
+
+			{Key: "SMBDMasterScore", Game: "SMBD", StartRow: 3, StartCol: 17, Amount: 12, IsTime: false},
+			{Key: "SMBDMasterExtraScore", Game: "SMBD", StartRow: 28, StartCol: 17, Amount: 10, IsTime: false},
+		},
+	},
+	{
+		Title: "SMBDX Story",
+		Sections: []section{
+			{Key: "SMBDStory1Time", Game: "SMBD", StartRow: 3, StartCol: 2, Amount: 20, IsTime: true},
+			{Key: "SMBDStory1Score", Game: "SMBD", StartRow: 3, StartCol: 7, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory2Time", Game: "SMBD", StartRow: 3, StartCol: 12, Amount: 20, IsTime: true},
+			{Key: "SMBDStory2Score", Game: "SMBD", StartRow: 3, StartCol: 17, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory3Time", Game: "SMBD", StartRow: 26, StartCol: 2, Amount: 20, IsTime: true},
+			{Key: "SMBDStory3Score", Game: "SMBD", StartRow: 26, StartCol: 7, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory4Time", Game: "SMBD", StartRow: 26, StartCol: 12, Amount: 20, IsTime: true},
+			{Key: "SMBDStory4Score", Game: "SMBD", StartRow: 26, StartCol: 17, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory5Time", Game: "SMBD", StartRow: 49, StartCol: 2, Amount: 20, IsTime: true},
+			{Key: "SMBDStory5Score", Game: "SMBD", StartRow: 49, StartCol: 7, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory6Time", Game: "SMBD", StartRow: 49, StartCol: 12, Amount: 20, IsTime: true},
+			{Key: "SMBDStory6Score", Game: "SMBD", StartRow: 49, StartCol: 17, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory7Time", Game: "SMBD", StartRow: 72, StartCol: 2, Amount: 20, IsTime: true},
+			{Key: "SMBDStory7Score", Game: "SMBD", StartRow: 72, StartCol: 7, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory8Time", Game: "SMBD", StartRow: 72, StartCol: 12, Amount: 20, IsTime: true},
+			{Key: "SMBDStory8Score", Game: "SMBD", StartRow: 72, StartCol: 17, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory9Time", Game: "SMBD", StartRow: 95, StartCol: 2, Amount: 20, IsTime: true},
+			{Key: "SMBDStory9Score", Game: "SMBD", StartRow: 95, StartCol: 7, Amount: 20, IsTime: false},
+
+			{Key: "SMBDStory10Time", Game: "SMBD", StartRow: 95, StartCol: 12, Amount: 20, IsTime: true},
+			{Key: "SMBDStory10Score", Game: "SMBD", StartRow: 95, StartCol: 17, Amount: 20, IsTime: false},
+		},
+	},
+}
+
+// challengeTab lays out an optional tab shaped like "SMB1 Time": the four
+// difficulties side by side from row 4, each with its extra stages five rows
+// below its last stage. stages and extras hold how many stages each
+// difficulty has, in the order of standardDifficulties.
+func challengeTab(title string, game string, variant string, isTime bool, stages [4]int, extras [4]int) tabLayout {
+	tab := tabLayout{Title: title, Optional: true}
+	for i, difficulty := range standardDifficulties {
+		col := 2 + 5*i
+		if stages[i] > 0 {
+			key := game + difficulty.Name + variant + scoreTypeKey(isTime)
+			tab.Sections = append(tab.Sections, section{Key: key, Game: game, StartRow: 3, StartCol: col, Amount: stages[i], IsTime: isTime, Variant: variant})
+		}
+		if extras[i] > 0 {
+			key := game + difficulty.Name + "Extra" + variant + scoreTypeKey(isTime)
+			tab.Sections = append(tab.Sections, section{Key: key, Game: game, StartRow: 3 + stages[i] + 5, StartCol: col, Amount: extras[i], IsTime: isTime, Variant: variant})
+		}
+	}
+	return tab
+}
+
+// Banana Mania has a tab per collection or mode for times and one for scores.
+// The SMB1 collection is the default, the others are variants of it.
+var bananaManiaTabs = func() []tabLayout {
+	var tabs []tabLayout
+	modes := []struct {
+		Title   string
+		Variant string
+		Stages  [4]int
+		Extras  [4]int
+	}{
+		{Title: "BM SMB1", Variant: "", Stages: [4]int{10, 30, 50, 10}, Extras: [4]int{3, 5, 10, 0}},
+		{Title: "BM SMB2", Variant: "SMB2", Stages: [4]int{10, 30, 50, 10}, Extras: [4]int{10, 10, 10, 10}},
+		{Title: "BM Deluxe", Variant: "Deluxe", Stages: [4]int{40, 70, 100, 12}, Extras: [4]int{20, 20, 20, 10}},
+		{Title: "BM Reverse", Variant: "Reverse", Stages: [4]int{10, 30, 50, 10}, Extras: [4]int{3, 5, 10, 0}},
+		{Title: "BM Golden Banana", Variant: "Golden", Stages: [4]int{10, 30, 50, 10}, Extras: [4]int{3, 5, 10, 0}},
+		{Title: "BM Dark Banana", Variant: "Dark", Stages: [4]int{10, 30, 50, 10}, Extras: [4]int{3, 5, 10, 0}},
+	}
+	for _, mode := range modes {
+		tabs = append(tabs, challengeTab(mode.Title+" Time", "BM", mode.Variant, true, mode.Stages, mode.Extras))
+		tabs = append(tabs, challengeTab(mode.Title+" Score", "BM", mode.Variant, false, mode.Stages, mode.Extras))
+	}
+	return tabs
+}()
+
+// sheetLayout is where every category of records is in the IL spreadsheet,
+// built from the tabs of every game in gameRegistry
+var sheetLayout = registryLayout()
+
+// findTabLayout returns the layout of the tab with the given title, or nil if
+// the tab holds no records
+func findTabLayout(title string) *tabLayout {
+	for i := range sheetLayout {
+		if sheetLayout[i].Title == title {
+			return &sheetLayout[i]
+		}
+	}
+	return nil
+}
+
+// Only the tab titles are needed to know which tabs exist
+const tabFields = "sheets(properties(title))"
+
+// Only the parts of each cell the parser uses are fetched from the sheet
+const sectionFields = "sheets(properties(title),data(startRow,startColumn,rowData(values(formattedValue,hyperlink))))"
+
+// sectionRanges returns one A1 range per tab, the smallest box covering every
+// section and its header, so only those cells have to be fetched and the URL
+// stays short. Asking for a tab that doesn't exist fails the whole request, so
+// only the tabs in present are included.
+func sectionRanges(present map[string]bool) []string {
+	var ranges []string
+	for _, tab := range sheetLayout {
+		if !present[tab.Title] || len(tab.Sections) == 0 {
+			continue
+		}
+		first := tab.Sections[0]
+		top, left := first.StartRow-1, first.StartCol
+		bottom, right := first.StartRow+first.Amount-1, first.StartCol+2
+		for _, sec := range tab.Sections[1:] {
+			top = minInt(top, sec.StartRow-1)
+			left = minInt(left, sec.StartCol)
+			bottom = maxInt(bottom, sec.StartRow+sec.Amount-1)
+			right = maxInt(right, sec.StartCol+2)
+		}
+		// Quotes in a tab title are escaped by doubling them
+		title := "'" + strings.Replace(tab.Title, "'", "''", -1) + "'"
+		ranges = append(ranges, title+"!"+cellName(top, left)+":"+cellName(bottom, right))
+	}
+	return ranges
+}
+
+// fetchRanges returns every range to fetch from the sheet: the record
+// sections, and the full game runs and stage rankings if the sheet has tabs
+// for them
+func fetchRanges(present map[string]bool) []string {
+	ranges := sectionRanges(present)
+	if present[fullGameTab] {
+		ranges = append(ranges, fullGameRange)
+	}
+	if present[rankingsTab] {
+		ranges = append(ranges, rankingsRange)
+	}
+	return ranges
+}
+
+// flattenGridData moves the range fetched for a tab into place in a single
+// grid starting at A1, the same shape as fetching the whole tab. Only the rows
+// and cells before the range are filled in; rows and cells the API leaves out
+// at the end stay missing, so short sections are still reported.
+func flattenGridData(sheet *sheets.Sheet) {
+	var rowData []*sheets.RowData
+	for _, data := range sheet.Data {
+		for i, row := range data.RowData {
+			r := int(data.StartRow) + i
+			for len(rowData) <= r {
+				rowData = append(rowData, &sheets.RowData{})
+			}
+			if row == nil {
+				continue
+			}
+			for j, cell := range row.Values {
+				c := int(data.StartColumn) + j
+				for len(rowData[r].Values) <= c {
+					rowData[r].Values = append(rowData[r].Values, &sheets.CellData{})
+				}
+				if cell != nil {
+					rowData[r].Values[c] = cell
+				}
+			}
+		}
+	}
+	sheet.Data = []*sheets.GridData{{RowData: rowData}}
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// recordSections returns every section users can query, in sheet order. The
+// alternate route sections aren't included.
+func recordSections() []section {
+	var sections []section
+	for _, tab := range sheetLayout {
+		for _, sec := range tab.Sections {
+			if !strings.HasSuffix(sec.Key, "Alt") {
+				sections = append(sections, sec)
+			}
+		}
+	}
+	return sections
+}
+
+// Category returns the part of the section's key between the game and the
+// score type (ex: "ExpertExtra" or "Story3")
+func (sec section) Category() string {
+	category := strings.TrimPrefix(sec.Key, sec.Game)
+	category = strings.TrimSuffix(category, "Time")
+	return strings.TrimSuffix(category, "Score")
+}
+
+// ScoreType returns "Time" or "Score"
+func (sec section) ScoreType() string {
+	return scoreTypeKey(sec.IsTime)
+}
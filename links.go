@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// videoLink is a video link that has been recognized and cleaned up
+type videoLink struct {
+	// Name of the site the video is on (ex: "YouTube" or "Twitch clip")
+	Platform string
+	// The link in its simplest form, keeping any timestamp
+	URL string
+}
+
+// Sites that are recognized by host but have no special handling
+var otherVideoHosts = map[string]string{
+	"streamable.com":   "Streamable",
+	"vimeo.com":        "Vimeo",
+	"bilibili.com":     "Bilibili",
+	"nicovideo.jp":     "Niconico",
+	"drive.google.com": "Google Drive",
+}
+
+var youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+var twitchVOD = regexp.MustCompile(`^(?:videos|[^/]+/v)/(\d+)$`)
+var twitchClip = regexp.MustCompile(`^(?:[^/]+/clip/)?([A-Za-z0-9_-]+)$`)
+var timestampPart = regexp.MustCompile(`(\d+)([hms]?)`)
+
+// parseVideoLink recognizes links to YouTube, Twitch VODs and clips and other
+// common video sites and normalizes them. Links to other sites are returned
+// as they are with an empty Platform.
+func parseVideoLink(link string) (videoLink, error) {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || parsed.Host == "" {
+		return videoLink{}, fmt.Errorf("not a valid link")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return videoLink{}, fmt.Errorf("not a web link")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	host = strings.TrimPrefix(host, "m.")
+	path := strings.Trim(parsed.Path, "/")
+	query := parsed.Query()
+
+	switch host {
+	case "youtube.com", "youtu.be":
+		return parseYouTubeLink(host, path, query)
+	case "twitch.tv", "clips.twitch.tv":
+		return parseTwitchLink(host, path, query)
+	}
+
+	for known, platform := range otherVideoHosts {
+		if host == known || strings.HasSuffix(host, "."+known) {
+			if path == "" {
+				return videoLink{}, fmt.Errorf("%s link without a video", platform)
+			}
+			parsed.Scheme = "https"
+			return videoLink{Platform: platform, URL: parsed.String()}, nil
+		}
+	}
+	return videoLink{URL: parsed.String()}, nil
+}
+
+// parseYouTubeLink normalizes every form of YouTube link (watch, short, embed,
+// live and youtu.be) into a youtu.be link
+func parseYouTubeLink(host string, path string, query url.Values) (videoLink, error) {
+	id := ""
+	if host == "youtu.be" {
+		id = path
+	} else if path == "watch" {
+		id = query.Get("v")
+	} else {
+		for _, prefix := range []string{"shorts/", "embed/", "live/", "v/"} {
+			if strings.HasPrefix(path, prefix) {
+				id = strings.TrimPrefix(path, prefix)
+			}
+		}
+	}
+	if !youtubeID.MatchString(id) {
+		return videoLink{}, fmt.Errorf("YouTube link without a video")
+	}
+
+	normalized := "https://youtu.be/" + id
+	start := query.Get("t")
+	if start == "" {
+		start = query.Get("start")
+	}
+	if seconds := timestampSeconds(start); seconds > 0 {
+		normalized += "?t=" + strconv.Itoa(seconds)
+	}
+	return videoLink{Platform: "YouTube", URL: normalized}, nil
+}
+
+// parseTwitchLink normalizes Twitch VOD links (including the old /v/ form) and
+// clip links
+func parseTwitchLink(host string, path string, query url.Values) (videoLink, error) {
+	if host == "clips.twitch.tv" || strings.Contains(path, "/clip/") {
+		match := twitchClip.FindStringSubmatch(path)
+		if match == nil {
+			return videoLink{}, fmt.Errorf("Twitch clip link without a clip")
+		}
+		return videoLink{Platform: "Twitch clip", URL: "https://clips.twitch.tv/" + match[1]}, nil
+	}
+
+	match := twitchVOD.FindStringSubmatch(path)
+	if match == nil {
+		return videoLink{}, fmt.Errorf("Twitch link without a video")
+	}
+	normalized := "https://www.twitch.tv/videos/" + match[1]
+	if seconds := timestampSeconds(query.Get("t")); seconds > 0 {
+		normalized += "?t=" + twitchTimestamp(seconds)
+	}
+	return videoLink{Platform: "Twitch", URL: normalized}, nil
+}
+
+// timestampSeconds converts a timestamp (ex: "90", "90s" or "1h2m30s") into
+// seconds, or 0 if there isn't one
+func timestampSeconds(timestamp string) int {
+	seconds := 0
+	for _, part := range timestampPart.FindAllStringSubmatch(timestamp, -1) {
+		value, _ := strconv.Atoi(part[1])
+		switch part[2] {
+		case "h":
+			seconds += value * 3600
+		case "m":
+			seconds += value * 60
+		default:
+			seconds += value
+		}
+	}
+	return seconds
+}
+
+// twitchTimestamp formats seconds the way Twitch links expect (ex: 1h2m30s)
+func twitchTimestamp(seconds int) string {
+	timestamp := ""
+	if seconds >= 3600 {
+		timestamp += strconv.Itoa(seconds/3600) + "h"
+	}
+	if seconds >= 60 {
+		timestamp += strconv.Itoa(seconds/60%60) + "m"
+	}
+	return timestamp + strconv.Itoa(seconds%60) + "s"
+}
+
+// formatVideo renders a video link for a reply, labelled with its platform.
+// Links are wrapped in <> so Discord doesn't embed them; see embedLinks.
+func formatVideo(link string) string {
+	video, err := parseVideoLink(link)
+	if err != nil {
+		return "<" + link + ">"
+	}
+	if video.Platform == "" {
+		return "<" + video.URL + ">"
+	}
+	return video.Platform + ": <" + video.URL + ">"
+}
+
+// Links wrapped in <> to keep Discord from embedding them
+var suppressedLink = regexp.MustCompile(`<(https?://[^\s>]+)>`)
+
+// embedLinks unwraps every suppressed link in a reply so Discord embeds them
+func embedLinks(text string) string {
+	return suppressedLink.ReplaceAllString(text, "$1")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stageRef points at one stage in the records
+type stageRef struct {
+	Game     string
+	Category string
+	Level    int
+}
+
+// gameFromName turns a user supplied game name into the game id used in map
+// keys. The game's key and display name work as well as its aliases.
+func gameFromName(name string) string {
+	name = strings.ToLower(name)
+	for _, game := range gameRegistry {
+		if name == strings.ToLower(game.Key) || name == strings.ToLower(game.Name) {
+			return game.Key
+		}
+		for _, alias := range game.Aliases {
+			if name == alias {
+				return game.Key
+			}
+		}
+	}
+	return ""
+}
+
+// gameDisplayName returns the name a game goes by in replies
+func gameDisplayName(game string) string {
+	if info := findGame(game); info != nil {
+		return info.Name
+	}
+	return game
+}
+
+// parseDifficulty turns a user supplied difficulty (ex: "e", "expert", "ex" or
+// "expert extra") into the name used in map keys (ex: "ExpertExtra")
+func parseDifficulty(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(name)
+
+	extra := ""
+	if strings.HasSuffix(name, "extra") {
+		name = strings.TrimSuffix(name, "extra")
+		extra = "Extra"
+	} else if len(name) == 2 && name[1] == 'x' {
+		name = name[:1]
+		extra = "Extra"
+	}
+
+	if difficulty := findDifficulty(name); difficulty != nil {
+		return difficulty.Name + extra
+	}
+	return ""
+}
+
+// parseCategory turns user supplied arguments into the part of a map key
+// between the game and the score type, either a difficulty (ex: "expert extra"
+// gives "ExpertExtra") or a story world (ex: "3" gives "Story3"). It also
+// returns how the category should be described in replies.
+func parseCategory(args []string) (category string, label string) {
+	if len(args) == 1 {
+		if world, err := strconv.Atoi(args[0]); err == nil {
+			category := "Story" + strconv.Itoa(world)
+			return category, categoryLabel("", category)
+		}
+	}
+	difficulty := parseDifficulty(strings.Join(args, " "))
+	if difficulty == "" {
+		return "", ""
+	}
+	return difficulty, categoryLabel("", difficulty)
+}
+
+// stageCode returns the command used to ask for a stage (ex: "ex3" for the
+// third expert extra stage, "s3-7" for world 3 floor 7 or "e10 reverse" for a
+// stage in one of a game's variants)
+func stageCode(game string, category string, level int) string {
+	category, variant := splitVariant(game, category)
+	suffix := ""
+	if variant != nil {
+		suffix = " " + variant.Aliases[0]
+	}
+	if strings.HasPrefix(category, "Story") {
+		return "s" + strings.TrimPrefix(category, "Story") + "-" + strconv.Itoa(level) + suffix
+	}
+	code := ""
+	if info := findGame(game); info != nil {
+		for _, difficulty := range info.Difficulties {
+			if strings.TrimSuffix(category, "Extra") == difficulty.Name {
+				code = difficulty.Code
+			}
+		}
+	}
+	if strings.HasSuffix(category, "Extra") {
+		code += "x"
+	}
+	return code + strconv.Itoa(level) + suffix
+}
+
+// categoryLabel describes a category in replies (ex: "Expert Extra", "World 3"
+// or "Expert Golden Banana")
+func categoryLabel(game string, category string) string {
+	category, variant := splitVariant(game, category)
+	label := strings.Replace(category, "Extra", " Extra", 1)
+	if strings.HasPrefix(category, "Story") {
+		label = "World " + strings.TrimPrefix(category, "Story")
+	}
+	if variant != nil {
+		label += " " + variant.Name
+	}
+	return label
+}
+
+var stageCodePattern = regexp.MustCompile(`^([` + difficultyCodes() + `])(x?)(\d+)$`)
+var storyCodePattern = regexp.MustCompile(`^s(\d+)-(\d+)$`)
+
+// parseStageCode is the reverse of stageCode, turning a stage like "ex3" or
+// "s3-7" into its category and level
+func parseStageCode(code string) (category string, level int, ok bool) {
+	code = strings.ToLower(code)
+	if match := storyCodePattern.FindStringSubmatch(code); match != nil {
+		world, _ := strconv.Atoi(match[1])
+		level, _ = strconv.Atoi(match[2])
+		return "Story" + strconv.Itoa(world), level, true
+	}
+	if match := stageCodePattern.FindStringSubmatch(code); match != nil {
+		level, _ = strconv.Atoi(match[3])
+		return parseDifficulty(match[1] + match[2]), level, true
+	}
+	return "", 0, false
+}
+
+// parseStage reads a stage from the start of args, with the game first if
+// there is one (ex: "smb2 b10" or "b10"). When the game is left out the stage
+// has to exist in only one of the games. It returns the arguments after the
+// stage, or a reply explaining what is wrong.
+func parseStage(args []string, games []string) (stage stageRef, rest []string, problem string) {
+	return parseStageFor(args, games, nil)
+}
+
+// parseStageFor is parseStage for someone who usually plays the preferred
+// games: when the game is left out and more than one game has the stage, the
+// first preferred game with it is used.
+func parseStageFor(args []string, games []string, preferred []string) (stage stageRef, rest []string, problem string) {
+	if len(args) > 0 {
+		if game := gameFromName(args[0]); game != "" {
+			if !hasGame(games, game) {
+				return stageRef{}, nil, "That game isn't available here"
+			}
+			games = []string{game}
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return stageRef{}, nil, "Which stage? (ex: b10, ex3 or s3-7)"
+	}
+	category, level, ok := parseStageCode(args[0])
+	if !ok {
+		return stageRef{}, nil, args[0] + " isn't a stage (ex: b10, ex3 or s3-7)"
+	}
+
+	// Find which games have the stage, in the variant named after it if any
+	var found []string
+	variants := make(map[string]string)
+	used := make(map[string]int)
+	for _, game := range games {
+		variants[game], used[game] = matchVariant(game, args[1:])
+		section, ok := records[game+category+variants[game]+"Time"]
+		if ok && level > 0 && level < len(section) && section[level].Time != "N/A" {
+			found = append(found, game)
+		}
+	}
+	if len(found) == 0 && len(games) == 1 && len(findGame(games[0]).Tabs) == 0 {
+		return stageRef{}, nil, "The sheet has no " + gameDisplayName(games[0]) + " records yet"
+	}
+	if len(found) == 0 {
+		// Include the variant in what was asked for if one was named
+		asked := 1
+		for _, game := range games {
+			asked = maxInt(asked, 1+used[game])
+		}
+		return stageRef{}, nil, "There is no stage " + strings.ToLower(strings.Join(args[:asked], " "))
+	}
+	if len(found) > 1 {
+		for _, game := range preferred {
+			if hasGame(found, game) {
+				found = []string{game}
+				break
+			}
+		}
+	}
+	if len(found) > 1 {
+		names := make([]string, len(found))
+		for i, game := range found {
+			names[i] = gameDisplayName(game)
+		}
+		return stageRef{}, nil, strings.ToLower(args[0]) + " is in " + strings.Join(names, ", ") + ", which game? (ex: smb2 " + strings.ToLower(args[0]) + ")"
+	}
+	game := found[0]
+	return stageRef{Game: game, Category: category + variants[game], Level: level}, args[1+used[game]:], ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+)
+
+// Reply for an open records query that can't be understood
+const openUsage = "Usage: !open [game] [time|score] (ex: !open, !open smb2, !open smbdx score)"
+
+// parseGameAndType reads an optional game and an optional "time" or "score"
+// from args, in any order. Games defaults to the given list and the score type
+// to both.
+func parseGameAndType(args []string, games []string) (filtered []string, scoreType string, ok bool) {
+	filtered = games
+	for _, arg := range args {
+		switch strings.ToLower(arg) {
+		case "time":
+			scoreType = "Time"
+		case "score":
+			scoreType = "Score"
+		default:
+			game := gameFromName(arg)
+			if game == "" || !hasGame(games, game) {
+				return nil, "", false
+			}
+			filtered = []string{game}
+		}
+	}
+	return filtered, scoreType, true
+}
+
+// buildOpenReply lists every stage that has no holder or no video, grouped by
+// game and difficulty, so people can find records worth going for
+func buildOpenReply(message string, games []string) string {
+	games, scoreType, ok := parseGameAndType(strings.Fields(message)[1:], games)
+	if !ok {
+		return openUsage
+	}
+
+	returnMessage := ""
+	for _, sec := range recordSections() {
+		if !hasGame(games, sec.Game) || (scoreType != "" && sec.ScoreType() != scoreType) {
+			continue
+		}
+		section, ok := records[sec.Key]
+		if !ok {
+			continue
+		}
+
+		var open, noVideo []string
+		for level := 1; level < len(section); level++ {
+			record := section[level]
+			// Duplicate stages have their records elsewhere
+			if record.Time == "N/A" {
+				continue
+			}
+			stage := stageCode(sec.Game, sec.Category(), level) + " (" + record.Name + ")"
+			if record.Holder == "" {
+				open = append(open, stage)
+			} else if record.Video == "" {
+				noVideo = append(noVideo, stage)
+			}
+		}
+		if len(open) == 0 && len(noVideo) == 0 {
+			continue
+		}
+
+		returnMessage += "**" + gameDisplayName(sec.Game) + " " + categoryLabel(sec.Game, sec.Category()) + " " + sec.ScoreType() + "**\n"
+		if len(open) > 0 {
+			returnMessage += "Open: " + strings.Join(open, ", ") + "\n"
+		}
+		if len(noVideo) > 0 {
+			returnMessage += "No video: " + strings.Join(noVideo, ", ") + "\n"
+		}
+	}
+	if returnMessage == "" {
+		return "Every record has a holder and a video"
+	}
+	return returnMessage
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	discordgo "github.com/bwmarrin/discordgo"
+)
+
+// Replies for personal best commands that can't be understood
+const (
+	pbUsage  = "Usage: !pb [game] <stage> <time|score> [video] (ex: !pb smb2 b10 12.34 https://youtu.be/...). Without a game, the game you have a PB on the stage in is used, or else the game of your latest PB that has it"
+	gapUsage = "Usage: !gap [game] <stage> (ex: !gap smb2 b10). Without a game, the game you have a PB on the stage in is used, or else the game of your latest PB that has it"
+)
+
+// personalBest is a time or score someone has submitted for themselves
+type personalBest struct {
+	UserID   string    `json:"userId"`
+	Username string    `json:"username"`
+	Game     string    `json:"game"`
+	Category string    `json:"category"`
+	Level    int       `json:"level"`
+	IsTime   bool      `json:"isTime"`
+	Value    string    `json:"value"`
+	Video    string    `json:"video,omitempty"`
+	Date     time.Time `json:"date"`
+}
+
+// Every personal best, saved to pbsFile whenever one changes
+var personalBests []personalBest
+var personalBestsLock sync.Mutex
+
+var userMention = regexp.MustCompile(`^<@!?(\d+)>$`)
+
+// loadPersonalBests reads the personal bests from pbsFile. A missing file just
+// means nobody has submitted one yet.
+func loadPersonalBests() error {
+	data, err := ioutil.ReadFile(*pbsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	personalBestsLock.Lock()
+	defer personalBestsLock.Unlock()
+	return json.Unmarshal(data, &personalBests)
+}
+
+// recordValue turns a time or score from the sheet into a number to compare
+func recordValue(isTime bool, value string) (float64, error) {
+	if isTime {
+		return parseTimeValue(value)
+	}
+	score, err := parseScoreValue(value)
+	return float64(score), err
+}
+
+// behindBy returns how far value is from record, where lower times and higher
+// scores are better. A negative result means value beats the record.
+func behindBy(isTime bool, value float64, record float64) float64 {
+	if isTime {
+		return value - record
+	}
+	return record - value
+}
+
+// formatValue formats a time or score the way the sheet does
+func formatValue(isTime bool, value float64) string {
+	if isTime {
+		return formatSeconds(value)
+	}
+	return strconv.Itoa(int(value))
+}
+
+// scoreTypeName returns "time" or "score"
+func scoreTypeName(isTime bool) string {
+	if isTime {
+		return "time"
+	}
+	return "score"
+}
+
+// scoreTypeKey returns "Time" or "Score", as used in map keys
+func scoreTypeKey(isTime bool) string {
+	if isTime {
+		return "Time"
+	}
+	return "Score"
+}
+
+// buildPBReply handles the personal best commands for the author of a
+// message. It returns "" if the message isn't a personal best command.
+func buildPBReply(message string, author *discordgo.User) string {
+	fields := strings.Fields(message)
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "!pb":
+		return submitPersonalBest(fields[1:], author)
+	case "!pbs":
+		return listPersonalBests(fields[1:], author)
+	case "!gap":
+		return personalBestGap(fields[1:], author)
+	case "!pbstats":
+		return personalBestStats(fields[1:], author)
+	}
+	return ""
+}
+
+// submitPersonalBest handles !pb, replacing any earlier personal best the
+// author had for the stage
+func submitPersonalBest(args []string, author *discordgo.User) string {
+	stage, rest, problem := parseStageFor(args, allGames, preferredGames(author.ID, args))
+	if problem != "" {
+		return problem + "\n" + pbUsage
+	}
+	if len(rest) < 1 || len(rest) > 2 {
+		return pbUsage
+	}
+
+	// Times have a decimal point, scores don't
+	value := rest[0]
+	isTime := strings.ContainsAny(value, ".:")
+	number, err := recordValue(isTime, value)
+	if err != nil {
+		return value + " isn't a time or a score\n" + pbUsage
+	}
+	video := ""
+	if len(rest) == 2 {
+		video = strings.Trim(rest[1], "<>")
+		if problem := checkVideoLink(video); problem != "" {
+			return "That video link doesn't look right (" + problem + ")"
+		}
+	}
+
+	pb := personalBest{UserID: author.ID, Username: author.Username, Game: stage.Game, Category: stage.Category, Level: stage.Level, IsTime: isTime, Value: value, Video: video, Date: time.Now().UTC()}
+
+	personalBestsLock.Lock()
+	replaced := false
+	for i, existing := range personalBests {
+		if existing.UserID == pb.UserID && existing.Game == pb.Game && existing.Category == pb.Category && existing.Level == pb.Level && existing.IsTime == pb.IsTime {
+			personalBests[i] = pb
+			replaced = true
+		}
+	}
+	if !replaced {
+		personalBests = append(personalBests, pb)
+	}
+	err = writeJSONFile(*pbsFile, personalBests)
+	personalBestsLock.Unlock()
+	if err != nil {
+		return "Your PB couldn't be saved: " + err.Error()
+	}
+
+	returnMessage := "Saved your " + gameDisplayName(stage.Game) + " " + stageCode(stage.Game, stage.Category, stage.Level) + " " + scoreTypeName(isTime) + " PB: " + formatValue(isTime, number)
+	return returnMessage + " (" + gapDescription(stage, isTime, number) + ")"
+}
+
+// gapDescription describes how a value compares to the stage's record
+func gapDescription(stage stageRef, isTime bool, value float64) string {
+	section := records[stage.Game+stage.Category+scoreTypeKey(isTime)]
+	if stage.Level >= len(section) || section[stage.Level].Holder == "" {
+		return "the record is open"
+	}
+	record := section[stage.Level]
+	recordNumber, err := recordValue(isTime, record.Time)
+	if err != nil {
+		return "the record can't be read"
+	}
+
+	gap := behindBy(isTime, value, recordNumber)
+	recordText := record.Time + " by " + record.Holder
+	if gap > 0 {
+		return formatValue(isTime, gap) + " behind the record of " + recordText
+	} else if gap == 0 {
+		return "ties the record of " + recordText
+	}
+	return "beats the record of " + recordText + " by " + formatValue(isTime, -gap) + "!"
+}
+
+// preferredGames returns the games to use for a user's stage when the game is
+// left out: the games the user has PBs for on the stage, then the games of
+// the rest of their PBs, latest first. Someone with no PBs in a game that has
+// the stage is asked which game they mean.
+func preferredGames(userID string, args []string) []string {
+	category, level := "", 0
+	if len(args) > 0 {
+		category, level, _ = parseStageCode(args[0])
+	}
+
+	personalBestsLock.Lock()
+	var pbs []personalBest
+	for _, pb := range personalBests {
+		if pb.UserID == userID {
+			pbs = append(pbs, pb)
+		}
+	}
+	personalBestsLock.Unlock()
+	sort.SliceStable(pbs, func(i, j int) bool {
+		return pbs[i].Date.After(pbs[j].Date)
+	})
+
+	var games []string
+	add := func(game string) {
+		if !hasGame(games, game) {
+			games = append(games, game)
+		}
+	}
+	for _, pb := range pbs {
+		if base, _ := splitVariant(pb.Game, pb.Category); category != "" && pb.Level == level && base == category {
+			add(pb.Game)
+		}
+	}
+	for _, pb := range pbs {
+		add(pb.Game)
+	}
+	return games
+}
+
+// findUser works out whose personal bests to show: the author if args is
+// empty, otherwise a mention or a username that has submitted personal bests
+func findUser(args []string, author *discordgo.User) (userID string, username string) {
+	if len(args) == 0 {
+		return author.ID, author.Username
+	}
+	name := strings.Join(args, " ")
+	if match := userMention.FindStringSubmatch(name); match != nil {
+		userID = match[1]
+	}
+
+	personalBestsLock.Lock()
+	defer personalBestsLock.Unlock()
+	for _, pb := range personalBests {
+		if pb.UserID == userID || (userID == "" && strings.EqualFold(pb.Username, name)) {
+			return pb.UserID, pb.Username
+		}
+	}
+	return "", name
+}
+
+// userPersonalBests returns a user's personal bests in sheet order
+func userPersonalBests(userID string) []personalBest {
+	personalBestsLock.Lock()
+	defer personalBestsLock.Unlock()
+
+	order := make(map[string]int)
+	for i, sec := range recordSections() {
+		order[sec.Key] = i
+	}
+	var pbs []personalBest
+	for _, pb := range personalBests {
+		if pb.UserID == userID {
+			pbs = append(pbs, pb)
+		}
+	}
+	sort.SliceStable(pbs, func(i, j int) bool {
+		a := order[pbs[i].Game+pbs[i].Category+scoreTypeKey(pbs[i].IsTime)]
+		b := order[pbs[j].Game+pbs[j].Category+scoreTypeKey(pbs[j].IsTime)]
+		if a != b {
+			return a < b
+		}
+		return pbs[i].Level < pbs[j].Level
+	})
+	return pbs
+}
+
+// listPersonalBests handles !pbs
+func listPersonalBests(args []string, author *discordgo.User) string {
+	userID, username := findUser(args, author)
+	pbs := userPersonalBests(userID)
+	if userID == "" || len(pbs) == 0 {
+		return username + " hasn't submitted any PBs"
+	}
+
+	returnMessage := username + "'s PBs:\n"
+	for _, pb := range pbs {
+		stage := stageRef{Game: pb.Game, Category: pb.Category, Level: pb.Level}
+		returnMessage += gameDisplayName(pb.Game) + " " + stageCode(pb.Game, pb.Category, pb.Level) + " " + getLevelName(pb.Game, pb.Category, "Time", pb.Level) + " " + scoreTypeKey(pb.IsTime) + ": " + pb.Value
+		if pb.Video != "" {
+			returnMessage += " (" + formatVideo(pb.Video) + ")"
+		}
+		if number, err := recordValue(pb.IsTime, pb.Value); err == nil {
+			returnMessage += " (" + gapDescription(stage, pb.IsTime, number) + ")"
+		}
+		returnMessage += "\n"
+	}
+	return returnMessage
+}
+
+// personalBestGap handles !gap, showing how far the author's personal bests
+// on a stage are from the records
+func personalBestGap(args []string, author *discordgo.User) string {
+	stage, rest, problem := parseStageFor(args, allGames, preferredGames(author.ID, args))
+	if problem != "" {
+		return problem + "\n" + gapUsage
+	}
+	if len(rest) != 0 {
+		return gapUsage
+	}
+
+	returnMessage := ""
+	for _, pb := range userPersonalBests(author.ID) {
+		if pb.Game != stage.Game || pb.Category != stage.Category || pb.Level != stage.Level {
+			continue
+		}
+		number, err := recordValue(pb.IsTime, pb.Value)
+		if err != nil {
+			continue
+		}
+		returnMessage += "Your " + scoreTypeName(pb.IsTime) + " PB " + pb.Value + ": " + gapDescription(stage, pb.IsTime, number) + "\n"
+	}
+	if returnMessage == "" {
+		return "You haven't submitted a PB for " + gameDisplayName(stage.Game) + " " + stageCode(stage.Game, stage.Category, stage.Level) + " (use !pb)"
+	}
+	return gameDisplayName(stage.Game) + " " + stageCode(stage.Game, stage.Category, stage.Level) + " " + getLevelName(stage.Game, stage.Category, "Time", stage.Level) + "\n" + returnMessage
+}
+
+// personalBestStats handles !pbstats, summarizing a user's personal bests
+func personalBestStats(args []string, author *discordgo.User) string {
+	userID, username := findUser(args, author)
+	pbs := userPersonalBests(userID)
+	if userID == "" || len(pbs) == 0 {
+		return username + " hasn't submitted any PBs"
+	}
+
+	perGame := make(map[string]int)
+	matched := 0
+	timeGaps, scoreGaps := 0.0, 0.0
+	timeCount, scoreCount := 0, 0
+	for _, pb := range pbs {
+		perGame[pb.Game]++
+
+		number, err := recordValue(pb.IsTime, pb.Value)
+		if err != nil {
+			continue
+		}
+		section := records[pb.Game+pb.Category+scoreTypeKey(pb.IsTime)]
+		if pb.Level >= len(section) || section[pb.Level].Holder == "" {
+			continue
+		}
+		recordNumber, err := recordValue(pb.IsTime, section[pb.Level].Time)
+		if err != nil {
+			continue
+		}
+		gap := behindBy(pb.IsTime, number, recordNumber)
+		if gap <= 0 {
+			matched++
+			continue
+		}
+		if pb.IsTime {
+			timeGaps += gap
+			timeCount++
+		} else {
+			scoreGaps += gap
+			scoreCount++
+		}
+	}
+
+	var games []string
+	for _, game := range allGames {
+		if perGame[game] > 0 {
+			games = append(games, gameDisplayName(game)+" "+strconv.Itoa(perGame[game]))
+		}
+	}
+	returnMessage := username + " has " + strconv.Itoa(len(pbs)) + " PBs (" + strings.Join(games, ", ") + ")\n"
+	returnMessage += strconv.Itoa(matched) + " tie or beat the record\n"
+	if timeCount > 0 {
+		returnMessage += "Average time behind the record: " + formatSeconds(timeGaps/float64(timeCount)) + "\n"
+	}
+	if scoreCount > 0 {
+		returnMessage += "Average score behind the record: " + strconv.Itoa(int(scoreGaps/float64(scoreCount))) + "\n"
+	}
+	return returnMessage
+}
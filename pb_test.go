@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	discordgo "github.com/bwmarrin/discordgo"
+)
+
+// usePersonalBests starts the test with no personal bests, saved to a
+// temporary file
+func usePersonalBests(t *testing.T, pbs []personalBest) {
+	t.Helper()
+	setFlag(t, pbsFile, filepath.Join(t.TempDir(), "pbs.json"))
+	personalBests = pbs
+	t.Cleanup(func() { personalBests = nil })
+}
+
+func TestPersonalBestWithoutGame(t *testing.T) {
+	loadFixtureRecords(t)
+	usePersonalBests(t, []personalBest{
+		{UserID: "2", Username: "Nambo", Game: "SMB1", Category: "Beginner", Level: 1, IsTime: true, Value: "30.00", Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{UserID: "2", Username: "Nambo", Game: "SMB2", Category: "Beginner", Level: 1, IsTime: true, Value: "30.00", Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{UserID: "2", Username: "Nambo", Game: "SMB1", Category: "Expert", Level: 10, IsTime: true, Value: "50.00", Date: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	newcomer := &discordgo.User{ID: "1", Username: "Alex"}
+	regular := &discordgo.User{ID: "2", Username: "Nambo"}
+
+	tests := []struct {
+		message string
+		author  *discordgo.User
+		want    string
+	}{
+		// b10 is in every game, so someone with no PBs is asked which one
+		{"!pb b10 12.34 https://youtu.be/Ab1cD2eF3gH", newcomer, "b10 is in SMB1, SMB2, SMBDX, Banana Mania, which game? (ex: smb2 b10)"},
+		{"!gap b10", newcomer, "b10 is in SMB1, SMB2, SMBDX, Banana Mania, which game? (ex: smb2 b10)"},
+		// Otherwise the game of their latest PB
+		{"!pb b10 12.34 https://youtu.be/Ab1cD2eF3gH", regular, "Saved your SMB2 b10 time PB: 12.34"},
+		{"!gap s3-7", regular, "You haven't submitted a PB for SMB2 s3-7"},
+		// Or the game they already have a PB on the stage in
+		{"!gap e10", regular, "SMB1 e10 (Expert 10)\nYour time PB 50.00:"},
+		{"!pb e10 49.00", regular, "Saved your SMB1 e10 time PB: 49.00"},
+		// Naming the game still works
+		{"!pb smbdx b10 12.34", regular, "Saved your SMBDX b10 time PB: 12.34"},
+	}
+	for _, test := range tests {
+		if got := buildPBReply(test.message, test.author); !strings.HasPrefix(got, test.want) {
+			t.Errorf("%s: got %q, want it to start with %q", test.message, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// runQuery answers a single record query from the terminal, printing exactly
+// what the bot would reply in Discord. It is used to check sheet layout
+// changes without starting the bot.
+//
+//	scorebot query '!e30'
+//	scorebot query -game smb2 -story 3-7
+//	scorebot query -offline -stage bx2
+func runQuery(args []string) {
+	queryFlags := flag.NewFlagSet("query", flag.ExitOnError)
+	game := queryFlags.String("game", "", "Only show records for this game ("+gameNameList()+")")
+	stage := queryFlags.String("stage", "", "Stage to look up (ex: b10, ex3)")
+	story := queryFlags.String("story", "", "Story stage to look up (ex: 3-7)")
+	offline := queryFlags.Bool("offline", false, "Use the cached records instead of fetching the sheet")
+	queryFlags.Parse(args)
+
+	// Work out which message to answer
+	message := strings.Join(queryFlags.Args(), " ")
+	if *stage != "" {
+		message = "!" + *stage
+	} else if *story != "" {
+		message = "!s" + *story
+	}
+	if message == "" {
+		fmt.Fprintln(os.Stderr, "usage: query [-game <game>] [-offline] (<message> | -stage <stage> | -story <world>-<floor>)")
+		os.Exit(2)
+	}
+
+	games := commandGames(*game)
+
+	loadCommandRecords(*offline)
+
+	reply := buildReply(message, games)
+	if reply == "" {
+		fmt.Fprintln(os.Stderr, "no reply")
+		os.Exit(1)
+	}
+	fmt.Print(reply)
+}
+
+// commandGames returns the games a subcommand should cover, all of them if
+// name is empty, exiting if the game is unknown
+func commandGames(name string) []string {
+	if name == "" {
+		return allGames
+	}
+	game := gameFromName(name)
+	if game == "" {
+		fmt.Fprintf(os.Stderr, "unknown game %q\n", name)
+		os.Exit(2)
+	}
+	return []string{game}
+}
+
+// gameNameList lists a name of every registered game for help text (ex:
+// "smb1, smb2, smbd or bm")
+func gameNameList() string {
+	var names []string
+	for _, game := range gameRegistry {
+		names = append(names, game.Aliases[0])
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// loadCommandRecords loads the records for a subcommand from the cache or the
+// sheet, exiting if they can't be loaded
+func loadCommandRecords(offline bool) {
+	err := loadFullGameFile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error loading full game leaderboards,", err)
+		os.Exit(1)
+	}
+	err = loadRankingsFile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error loading stage rankings,", err)
+		os.Exit(1)
+	}
+	if offline {
+		err = loadSnapshot(*cacheFile)
+	} else {
+		err = loadKnownHeaders()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error loading sheet headers,", err)
+			os.Exit(1)
+		}
+		initializeSheets()
+		err = fetchRecords()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error loading records,", err)
+		os.Exit(1)
+	}
+}
+
+// saveSnapshot writes the current records to filename as JSON
+func saveSnapshot(filename string) error {
+	return writeJSONFile(filename, records)
+}
+
+// writeJSONFile writes v to filename as JSON. It writes to a temporary file
+// first so a crash never leaves a partial file behind.
+func writeJSONFile(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filename+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// loadSnapshot replaces the current records with the ones cached in filename
+func loadSnapshot(filename string) error {
+	snapshot, err := readSnapshot(filename)
+	if err != nil {
+		return err
+	}
+	records = snapshot
+	return nil
+}
+
+// readSnapshot returns the records cached in filename
+func readSnapshot(filename string) (map[string][]Record, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string][]Record)
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %v", filename, err)
+	}
+	return snapshot, nil
+}
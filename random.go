@@ -0,0 +1,96 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reply for a random stage query that can't be understood
+const randomUsage = "Usage: !random [game] [difficulty|story] [count] [noextra] [seed=<seed>] (ex: !random smb2 expert 5 seed=race1)"
+
+// Most stages that can be drawn at once
+const maxRandomStages = 25
+
+// buildRandomReply draws random stages from the records. The same seed with
+// the same options always draws the same stages, so a race can share a draw.
+func buildRandomReply(message string, games []string) string {
+	category := ""
+	count := 1
+	noExtra := false
+	seed := ""
+	for _, arg := range strings.Fields(message)[1:] {
+		lower := strings.ToLower(arg)
+		if strings.HasPrefix(lower, "seed=") {
+			seed = arg[len("seed="):]
+		} else if lower == "noextra" {
+			noExtra = true
+		} else if lower == "story" {
+			category = "Story"
+		} else if n, err := strconv.Atoi(arg); err == nil {
+			count = n
+		} else if game := gameFromName(arg); game != "" && hasGame(games, game) {
+			games = []string{game}
+		} else if difficulty := parseDifficulty(arg); difficulty != "" {
+			category = difficulty
+		} else {
+			return randomUsage
+		}
+	}
+	if count < 1 || count > maxRandomStages {
+		return "You can draw 1 to " + strconv.Itoa(maxRandomStages) + " stages"
+	}
+
+	// Every stage that matches, skipping duplicate story stages
+	var pool []stageRef
+	for _, sec := range recordSections() {
+		if !sec.IsTime || !hasGame(games, sec.Game) {
+			continue
+		}
+		secCategory := sec.Category()
+		baseCategory := strings.TrimSuffix(secCategory, sec.Variant)
+		if category == "Story" && !strings.HasPrefix(baseCategory, "Story") {
+			continue
+		}
+		if category != "" && category != "Story" && baseCategory != category {
+			continue
+		}
+		if noExtra && strings.HasSuffix(baseCategory, "Extra") {
+			continue
+		}
+		section := records[sec.Key]
+		for level := 1; level < len(section); level++ {
+			if section[level].Time != "N/A" {
+				pool = append(pool, stageRef{Game: sec.Game, Category: secCategory, Level: level})
+			}
+		}
+	}
+	if len(pool) == 0 {
+		return "There are no stages to draw from"
+	}
+	if count > len(pool) {
+		count = len(pool)
+	}
+
+	// Make up a seed if there isn't one so the draw can still be shared
+	if seed == "" {
+		seed = strconv.FormatInt(time.Now().UnixNano()%1000000, 10)
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(seed))
+	generator := rand.New(rand.NewSource(int64(hash.Sum64())))
+
+	returnMessage := "Seed: " + seed + "\n"
+	for i, pick := range generator.Perm(len(pool))[:count] {
+		stage := pool[pick]
+		name := gameDisplayName(stage.Game) + " " + stageCode(stage.Game, stage.Category, stage.Level) + " " + getLevelName(stage.Game, stage.Category, "Time", stage.Level)
+		returnMessage += strconv.Itoa(i+1) + ". " + name + ": " + retrieveRecordString(stage.Game, stage.Category, "Time", stage.Level)
+		if score := retrieveRecordString(stage.Game, stage.Category, "Score", stage.Level); score != "" {
+			returnMessage += ", " + score
+		}
+		returnMessage += "\n"
+	}
+	return returnMessage
+}
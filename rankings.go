@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Title of the optional tab runs behind the records are listed in, and the
+// cells to fetch from it: game, stage, type (time or score), player, time or
+// score (linked to the video) and date on every row after the header
+const (
+	rankingsTab   = "Rankings"
+	rankingsRange = "'Rankings'!A2:F"
+)
+
+// The most runs a top query can show
+const maxTopRuns = 25
+
+// rankedRun is one run on a stage's leaderboard
+type rankedRun struct {
+	Rank   int    `json:"rank,omitempty"`
+	Holder string `json:"holder"`
+	Value  string `json:"value"`
+	Video  string `json:"video,omitempty"`
+	Date   string `json:"date,omitempty"`
+}
+
+// rankedStage is a stage's time or score leaderboard as it is written in the
+// rankings file, with the stage written like a stage query (ex: "b10", "s3-7"
+// or "e10 reverse") and the type "time" or "score"
+type rankedStage struct {
+	Game  string      `json:"game"`
+	Stage string      `json:"stage"`
+	Type  string      `json:"type"`
+	Runs  []rankedRun `json:"runs"`
+}
+
+// Every stage's runs besides the record, keyed by rankingKey. Runs from the
+// sheet replace the ones from rankingsFile for the same stage.
+var stageRankings = make(map[string][]rankedRun)
+var fileRankings = make(map[string][]rankedRun)
+
+// A top query: a stage query followed by "top" and how many runs to show
+// (ex: "!b10 top5", "!smb2 s3-7 top 3" or "!bm e10 reverse top5")
+var topQueryPattern = regexp.MustCompile(`^!(.+?)\s+top\s*(\d+)$`)
+
+// rankingKey returns the key of a stage's time or score runs in stageRankings
+func rankingKey(game string, category string, isTime bool, level int) string {
+	return game + category + scoreTypeKey(isTime) + "#" + strconv.Itoa(level)
+}
+
+// rankingStage reads a stage written like a stage query (ex: "e10 reverse")
+// for a game, returning its category and level
+func rankingStage(game string, stage string) (string, int, error) {
+	fields := strings.Fields(stage)
+	if len(fields) == 0 {
+		return "", 0, fmt.Errorf("no stage given")
+	}
+	category, level, ok := parseStageCode(fields[0])
+	if !ok {
+		return "", 0, fmt.Errorf("%q isn't a stage", stage)
+	}
+	variant, used := matchVariant(game, fields[1:])
+	if used != len(fields)-1 {
+		return "", 0, fmt.Errorf("%q isn't a variant of %s", strings.Join(fields[1+used:], " "), gameDisplayName(game))
+	}
+	return category + variant, level, nil
+}
+
+// parseRankingType reads whether runs are times or scores
+func parseRankingType(scoreType string) (bool, error) {
+	switch strings.ToLower(scoreType) {
+	case "time":
+		return true, nil
+	case "score":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected time or score, found %q", scoreType)
+}
+
+// addRankedRun puts a time or score on its stage's leaderboard in rankings
+func addRankedRun(rankings map[string][]rankedRun, game string, stage string, isTime bool, run rankedRun) error {
+	category, level, err := rankingStage(game, stage)
+	if err != nil {
+		return err
+	}
+	if _, err := recordValue(isTime, run.Value); err != nil {
+		return err
+	}
+	key := rankingKey(game, category, isTime, level)
+	rankings[key] = append(rankings[key], run)
+	return nil
+}
+
+// parseRankingsTab reads the runs in the Rankings tab. Rows that can't be
+// read are skipped and their problems returned.
+func parseRankingsTab(sheet *sheets.Sheet) (map[string][]rankedRun, []sheetIssue) {
+	rankings := make(map[string][]rankedRun)
+	var issues []sheetIssue
+	issue := func(row int, col int, format string, args ...interface{}) {
+		issues = append(issues, sheetIssue{Tab: rankingsTab, Section: "Rankings", Row: row, Column: col, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for _, data := range sheet.Data {
+		for i := range data.RowData {
+			// Skip the header in case the whole tab was fetched
+			row := int(data.StartRow) + i
+			if row == 0 {
+				continue
+			}
+			gameName := cellText(data.RowData, i, 0)
+			stage := cellText(data.RowData, i, 1)
+			scoreType := cellText(data.RowData, i, 2)
+			holder := cellText(data.RowData, i, 3)
+			value := cellText(data.RowData, i, 4)
+			if gameName == "" && stage == "" && scoreType == "" && holder == "" && value == "" {
+				continue
+			}
+
+			game := gameFromName(gameName)
+			if game == "" {
+				issue(row, 0, "unknown game %q", gameName)
+				continue
+			}
+			isTime, err := parseRankingType(scoreType)
+			if err != nil {
+				issue(row, 2, "%v", err)
+				continue
+			}
+			if holder == "" {
+				issue(row, 3, "run needs a player")
+				continue
+			}
+			if _, err := recordValue(isTime, value); err != nil {
+				issue(row, 4, "%v", err)
+				continue
+			}
+			video := ""
+			if cell := cellAt(data.RowData, i, 4); cell != nil {
+				video = cell.Hyperlink
+			}
+			run := rankedRun{Holder: holder, Value: value, Video: video, Date: cellText(data.RowData, i, 5)}
+			if err := addRankedRun(rankings, game, stage, isTime, run); err != nil {
+				issue(row, 1, "%v", err)
+			}
+		}
+	}
+	return rankings, issues
+}
+
+// loadRankingsFile reads stage leaderboards from rankingsFile, a JSON list of
+// stages. Nothing is loaded if the flag isn't set.
+func loadRankingsFile() error {
+	if *rankingsFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*rankingsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []rankedStage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	rankings := make(map[string][]rankedRun)
+	for _, stage := range list {
+		game := gameFromName(stage.Game)
+		if game == "" {
+			return fmt.Errorf("%s: unknown game %q", *rankingsFile, stage.Game)
+		}
+		isTime, err := parseRankingType(stage.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %s %s: %v", *rankingsFile, stage.Game, stage.Stage, err)
+		}
+		for _, run := range stage.Runs {
+			if err := addRankedRun(rankings, game, stage.Stage, isTime, run); err != nil {
+				return fmt.Errorf("%s: %s %s: %v", *rankingsFile, stage.Game, stage.Stage, err)
+			}
+		}
+	}
+	fileRankings = rankings
+	setStageRankings(nil)
+	return nil
+}
+
+// setStageRankings makes the runs from the sheet, along with the ones from
+// the file for stages the sheet doesn't have, the current rankings
+func setStageRankings(sheetRankings map[string][]rankedRun) {
+	rankings := make(map[string][]rankedRun)
+	for key, runs := range fileRankings {
+		rankings[key] = runs
+	}
+	for key, runs := range sheetRankings {
+		rankings[key] = runs
+	}
+	stageRankings = rankings
+}
+
+// topRuns returns a stage's leaderboard, best first: the record from the sheet
+// along with the stage's ranked runs, keeping only each player's best run.
+// Tied runs share a rank and the next run skips the ranks they took up.
+func topRuns(game string, category string, isTime bool, level int) []rankedRun {
+	var runs []rankedRun
+	section := records[game+category+scoreTypeKey(isTime)]
+	if level > 0 && level < len(section) && section[level].Holder != "" {
+		record := section[level]
+		runs = append(runs, rankedRun{Holder: record.Holder, Value: record.Time, Video: record.Video})
+	}
+	runs = append(runs, stageRankings[rankingKey(game, category, isTime, level)]...)
+
+	// Only each player's best run counts
+	best := make(map[string]int)
+	var kept []rankedRun
+	for _, run := range runs {
+		value, err := recordValue(isTime, run.Value)
+		if err != nil {
+			continue
+		}
+		player := strings.ToLower(run.Holder)
+		if i, ok := best[player]; ok {
+			old, _ := recordValue(isTime, kept[i].Value)
+			if behindBy(isTime, value, old) < 0 {
+				kept[i] = run
+			}
+			continue
+		}
+		best[player] = len(kept)
+		kept = append(kept, run)
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		a, _ := recordValue(isTime, kept[i].Value)
+		b, _ := recordValue(isTime, kept[j].Value)
+		return behindBy(isTime, a, b) < 0
+	})
+	assignRanks(len(kept), func(i int) float64 {
+		value, _ := recordValue(isTime, kept[i].Value)
+		return value
+	}, func(i int, rank int) {
+		kept[i].Rank = rank
+	})
+	return kept
+}
+
+// assignRanks ranks n runs that are sorted best first, reading each run's
+// value with value and giving it its rank with setRank. Tied runs share a rank
+// and the next run skips the ranks they took up (1, 2, 2, 4).
+func assignRanks(n int, value func(i int) float64, setRank func(i int, rank int)) {
+	rank := 0
+	for i := 0; i < n; i++ {
+		if i == 0 || value(i) != value(i-1) {
+			rank = i + 1
+		}
+		setRank(i, rank)
+	}
+}
+
+// rankedCount returns how many of n ranked runs to show for the top count,
+// which is every run ranked count or better, so runs tied for the last place
+// shown are all included
+func rankedCount(n int, rank func(i int) int, count int) int {
+	shown := 0
+	for shown < n && rank(shown) <= count {
+		shown++
+	}
+	return shown
+}
+
+// formatTopRuns lists the runs ranked count or better, so runs tied for the
+// last place shown are all included
+func formatTopRuns(runs []rankedRun, count int) string {
+	if len(runs) == 0 {
+		return "No runs yet (Could be you)\n"
+	}
+	returnMessage := ""
+	shown := rankedCount(len(runs), func(i int) int { return runs[i].Rank }, count)
+	for _, run := range runs[:shown] {
+		returnMessage += strconv.Itoa(run.Rank) + ". " + run.Value + " (" + run.Holder + ")"
+		if run.Video != "" {
+			returnMessage += " (" + formatVideo(run.Video) + ")"
+		}
+		returnMessage += "\n"
+	}
+	return returnMessage
+}
+
+// isTopQuery reports whether the message asks for the top runs of a stage
+func isTopQuery(message string) bool {
+	return topQueryPattern.MatchString(message)
+}
+
+// buildTopReply answers a top query with the best times and scores of the
+// stage in every game it is in, or just the game named before the stage
+func buildTopReply(message string, games []string) string {
+	match := topQueryPattern.FindStringSubmatch(message)
+	count, _ := strconv.Atoi(match[2])
+	if count < 1 || count > maxTopRuns {
+		return "You can show the top 1 to " + strconv.Itoa(maxTopRuns) + " runs"
+	}
+
+	args := strings.Fields(match[1])
+	if game := gameFromName(args[0]); game != "" {
+		if !hasGame(games, game) {
+			return ""
+		}
+		games = []string{game}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	category, level, ok := parseStageCode(args[0])
+	if !ok {
+		return ""
+	}
+
+	returnMessage := ""
+	for _, game := range games {
+		variant, used := matchVariant(game, args[1:])
+		if used != len(args)-1 {
+			continue
+		}
+		gameCategory := category + variant
+		section := records[game+gameCategory+"Time"]
+		if level < 1 || level >= len(section) || section[level].Time == "N/A" {
+			continue
+		}
+
+		name := gameDisplayName(game)
+		if _, v := splitVariant(game, gameCategory); v != nil {
+			name += " " + v.Name
+		}
+		name += " " + getLevelName(game, gameCategory, "Time", level)
+		for _, isTime := range []bool{true, false} {
+			returnMessage += name + " top " + strconv.Itoa(count) + " " + scoreTypeName(isTime) + "s:\n"
+			returnMessage += formatTopRuns(topRuns(game, gameCategory, isTime, level), count)
+		}
+	}
+	return returnMessage
+}
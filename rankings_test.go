@@ -0,0 +1,127 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// loadTestRankings loads the fixture records along with the stage rankings
+// from the fixture file and the Rankings tab of the fixture spreadsheet
+func loadTestRankings(t *testing.T) {
+	t.Helper()
+	loadFixtureRecords(t)
+	setFlag(t, rankingsFile, filepath.Join("testdata", "rankings.json"))
+	if err := loadRankingsFile(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fileRankings = make(map[string][]rankedRun)
+		setStageRankings(nil)
+	})
+	rankings, issues := parseRankingsTab(findSheet(loadFixture(t, "spreadsheet.json"), rankingsTab))
+	if len(issues) != 0 {
+		t.Fatalf("unexpected issues parsing the fixture:\n%s", formatIssues("", issues))
+	}
+	setStageRankings(rankings)
+}
+
+// rankList writes runs as "rank holder value" separated by commas
+func rankList(runs []rankedRun) string {
+	var list []string
+	for _, run := range runs {
+		list = append(list, strings.Join([]string{strconv.Itoa(run.Rank), run.Holder, run.Value}, " "))
+	}
+	return strings.Join(list, ", ")
+}
+
+func TestTopRuns(t *testing.T) {
+	loadTestRankings(t)
+
+	tests := []struct {
+		name     string
+		game     string
+		category string
+		isTime   bool
+		level    int
+		want     string
+	}{
+		// Ties share a rank and the next run skips the ranks they took up
+		{"tied times", "SMB1", "Beginner", true, 1, "1 CyclopsDragon 21.75, 1 Nambo 21.75, 3 Alex 22.00, 4 bobjrsenior 23.10"},
+		{"tied scores", "SMB1", "Beginner", false, 1, "1 Ghost Ship 3,635, 1 Nambo 3,635, 3 Alex 3,600, 4 bobjrsenior 1,200"},
+		// Runs from the file, for a stage the sheet has no runs for
+		{"file times", "SMB2", "Beginner", true, 1, "1 Ghost Ship 28.80, 1 Alex 28.80, 3 Nambo 29.01"},
+		{"file scores", "SMB2", "Beginner", false, 1, "1 Nambo 5,600, 2 CyclopsDragon 5,527"},
+		// A time written without a decimal point is still a time
+		{"whole second time", "SMB2", "Story3", true, 7, "1 Alex 40.12, 2 Nambo 59"},
+		{"no runs", "SMB2", "Story3", false, 7, ""},
+		{"variant", "BM", "ExpertReverse", true, 10, "1 CyclopsDragon 46.72, 1 Nambo 46.72, 3 Alex 47.00"},
+	}
+	for _, test := range tests {
+		if got := rankList(topRuns(test.game, test.category, test.isTime, test.level)); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTopQueries(t *testing.T) {
+	loadTestRankings(t)
+
+	tests := []struct {
+		query string
+		games []string
+		want  string
+	}{
+		// Every run tied for the last place shown is included
+		{"!smb1 b1 top 1", allGames, "SMB1 (Beginner 1) top 1 times:\n" +
+			"1. 21.75 (CyclopsDragon) (Twitch: <https://www.twitch.tv/videos/329283573>)\n" +
+			"1. 21.75 (Nambo)\n" +
+			"SMB1 (Beginner 1) top 1 scores:\n" +
+			"1. 3,635 (Ghost Ship) (Twitch: <https://www.twitch.tv/videos/272908777>)\n" +
+			"1. 3,635 (Nambo)\n"},
+		{"!smb2 s3-7 top1", allGames, "SMB2 (World 3-7) top 1 times:\n" +
+			"1. 40.12 (Alex)\n" +
+			"SMB2 (World 3-7) top 1 scores:\n" +
+			"No runs yet (Could be you)\n"},
+		{"!bm e10 reverse top5", []string{"SMB2"}, ""},
+		{"!b1 top0", allGames, "You can show the top 1 to 25 runs"},
+		{"!b1 top26", allGames, "You can show the top 1 to 25 runs"},
+		{"!b99 top5", allGames, ""},
+		{"!q1 top5", allGames, ""},
+	}
+	for _, test := range tests {
+		if got := buildReply(test.query, test.games); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+
+	// A top query is answered for every game with the stage
+	reply := buildReply("!b1 top5", allGames)
+	for _, stage := range []string{"SMB1 (Beginner 1)", "SMB2 (Simple)", "SMBDX (Beginner 1)", "Banana Mania (Beginner 1)"} {
+		if !strings.Contains(reply, stage+" top 5 times:\n") {
+			t.Errorf("!b1 top5 doesn't show %s:\n%s", stage, reply)
+		}
+	}
+}
+
+func TestRankingsTabIssues(t *testing.T) {
+	_, issues := parseRankingsTab(findSheet(loadFixture(t, "broken.json"), rankingsTab))
+	want := []string{
+		`'Rankings'!A3 (Rankings): unknown game "SMB3"`,
+		`'Rankings'!D4 (Rankings): run needs a player`,
+		`'Rankings'!B5 (Rankings): "q1" isn't a stage`,
+		`'Rankings'!B6 (Rankings): "reverse" isn't a variant of SMB1`,
+		`'Rankings'!E7 (Rankings): invalid time "fast"`,
+		`'Rankings'!C8 (Rankings): expected time or score, found ""`,
+		`'Rankings'!E9 (Rankings): invalid score "22.00"`,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues, want %d:\n%s", len(issues), len(want), formatIssues("", issues))
+	}
+	for i, issue := range issues {
+		if issue.String() != want[i] {
+			t.Errorf("issue %d: got %q, want %q", i, issue.String(), want[i])
+		}
+	}
+}
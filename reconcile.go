@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// recordDifference is one way a stage's record differs between two sources
+type recordDifference struct {
+	Key   string `json:"key"`
+	Game  string `json:"game"`
+	Stage string `json:"stage"`
+	Name  string `json:"name"`
+	// What differs: "holder", "time", "score", "video", "name" or "moved"
+	Field string `json:"field"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+	// Which side is better for a time or score (ex: "0.38 faster in right")
+	Detail string `json:"detail,omitempty"`
+}
+
+// reconcileReport is everything that differs between two record snapshots
+type reconcileReport struct {
+	Left        string             `json:"left"`
+	Right       string             `json:"right"`
+	Compared    int                `json:"compared"`
+	OnlyLeft    []string           `json:"onlyLeft,omitempty"`
+	OnlyRight   []string           `json:"onlyRight,omitempty"`
+	Differences []recordDifference `json:"differences"`
+}
+
+// snapshotKeys returns the keys of both snapshots in sheet order, with keys
+// the layout doesn't know about sorted at the end
+func snapshotKeys(left map[string][]Record, right map[string][]Record) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, sec := range recordSections() {
+		_, inLeft := left[sec.Key]
+		_, inRight := right[sec.Key]
+		if inLeft || inRight {
+			keys = append(keys, sec.Key)
+			seen[sec.Key] = true
+		}
+	}
+	var extra []string
+	for _, snapshot := range []map[string][]Record{left, right} {
+		for key := range snapshot {
+			if !seen[key] {
+				extra = append(extra, key)
+				seen[key] = true
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(keys, extra...)
+}
+
+// sectionStage returns the stage code of a level of a section, or just the
+// level if the section isn't in the layout
+func sectionStage(key string, level int) string {
+	for _, sec := range recordSections() {
+		if sec.Key == key {
+			return stageCode(sec.Game, sec.Category(), level)
+		}
+	}
+	return fmt.Sprint(level)
+}
+
+// reconcileRecords compares two record snapshots stage by stage. Stages are
+// matched by game, difficulty and number, and the names have to agree: a
+// stage whose name is at another number on the right is compared there
+// instead and reported as moved.
+func reconcileRecords(leftName string, left map[string][]Record, rightName string, right map[string][]Record) reconcileReport {
+	report := reconcileReport{Left: leftName, Right: rightName, Differences: []recordDifference{}}
+	for _, key := range snapshotKeys(left, right) {
+		leftSection, inLeft := left[key]
+		rightSection, inRight := right[key]
+		if !inRight {
+			report.OnlyLeft = append(report.OnlyLeft, key)
+			continue
+		}
+		if !inLeft {
+			report.OnlyRight = append(report.OnlyRight, key)
+			continue
+		}
+
+		// Where each stage name is on the right
+		rightLevels := make(map[string]int)
+		for level := 1; level < len(rightSection); level++ {
+			rightLevels[stageIdentity(rightSection[level].Name)] = level
+		}
+
+		for level := 1; level < len(leftSection); level++ {
+			ours := leftSection[level]
+			if ours.Time == "N/A" {
+				continue
+			}
+			difference := recordDifference{Key: key, Game: ours.Game, Stage: sectionStage(key, level), Name: ours.Name}
+			add := func(field string, leftValue string, rightValue string, detail string) {
+				difference.Field, difference.Left, difference.Right, difference.Detail = field, leftValue, rightValue, detail
+				report.Differences = append(report.Differences, difference)
+			}
+
+			// Match the stage by number, or by name if it moved
+			rightLevel := level
+			identity := stageIdentity(ours.Name)
+			if level >= len(rightSection) || stageIdentity(rightSection[level].Name) != identity {
+				moved, ok := rightLevels[identity]
+				if !ok || identity == "" {
+					theirName := "missing"
+					if level < len(rightSection) {
+						theirName = rightSection[level].Name
+					}
+					add("name", ours.Name, theirName, "")
+					continue
+				}
+				add("moved", sectionStage(key, level), sectionStage(key, moved), "")
+				rightLevel = moved
+			}
+			theirs := rightSection[rightLevel]
+			report.Compared++
+
+			// Open records on both sides agree
+			if ours.Holder == "" && theirs.Holder == "" {
+				continue
+			}
+			if !strings.EqualFold(ours.Holder, theirs.Holder) {
+				add("holder", holderOrOpen(ours), holderOrOpen(theirs), "")
+			}
+			if ours.Holder != "" && theirs.Holder != "" && ours.Time != theirs.Time {
+				ourValue, ourErr := recordValue(ours.IsTime, ours.Time)
+				theirValue, theirErr := recordValue(ours.IsTime, theirs.Time)
+				if ourErr != nil || theirErr != nil || ourValue != theirValue {
+					add(scoreTypeName(ours.IsTime), ours.Time, theirs.Time, betterSide(ours.IsTime, ourValue, theirValue, ourErr == nil && theirErr == nil))
+				}
+			}
+			if ours.Holder != "" && theirs.Holder != "" && ours.Video != theirs.Video {
+				add("video", videoOrMissing(ours.Video), videoOrMissing(theirs.Video), "")
+			}
+		}
+
+		// Stages that are only on the right
+		leftLevels := make(map[string]bool)
+		for level := 1; level < len(leftSection); level++ {
+			leftLevels[stageIdentity(leftSection[level].Name)] = true
+		}
+		for level := len(leftSection); level < len(rightSection); level++ {
+			theirs := rightSection[level]
+			if !leftLevels[stageIdentity(theirs.Name)] && theirs.Time != "N/A" {
+				report.Differences = append(report.Differences, recordDifference{Key: key, Game: theirs.Game, Stage: sectionStage(key, level), Name: theirs.Name, Field: "name", Left: "missing", Right: theirs.Name})
+			}
+		}
+	}
+	return report
+}
+
+// holderOrOpen returns a record's holder, or "open" if nobody holds it
+func holderOrOpen(record Record) string {
+	if record.Holder == "" {
+		return "open"
+	}
+	return record.Holder
+}
+
+// videoOrMissing returns a video link, or "missing" if there is none
+func videoOrMissing(video string) string {
+	if video == "" {
+		return "missing"
+	}
+	return video
+}
+
+// betterSide describes which of two times or scores is better and by how much
+func betterSide(isTime bool, left float64, right float64, ok bool) string {
+	if !ok {
+		return ""
+	}
+	side := "right"
+	gap := behindBy(isTime, left, right)
+	if gap < 0 {
+		side = "left"
+		gap = -gap
+	}
+	if isTime {
+		return formatSeconds(gap) + " faster in " + side
+	}
+	return formatValue(false, gap) + " higher in " + side
+}
+
+// formatReconcileReport describes a report for people, one difference per line
+func formatReconcileReport(report reconcileReport) string {
+	text := "Comparing " + report.Left + " (left) with " + report.Right + " (right): " + fmt.Sprint(report.Compared) + " stages, " + fmt.Sprint(len(report.Differences)) + " differences\n"
+	if len(report.OnlyLeft) > 0 {
+		text += "Only in left: " + strings.Join(report.OnlyLeft, ", ") + "\n"
+	}
+	if len(report.OnlyRight) > 0 {
+		text += "Only in right: " + strings.Join(report.OnlyRight, ", ") + "\n"
+	}
+	lastKey := ""
+	for _, difference := range report.Differences {
+		if difference.Key != lastKey {
+			text += difference.Key + "\n"
+			lastKey = difference.Key
+		}
+		line := "  " + difference.Stage + " (" + difference.Name + ") " + difference.Field + ": " + difference.Left + " vs " + difference.Right
+		if difference.Detail != "" {
+			line += " (" + difference.Detail + ")"
+		}
+		text += line + "\n"
+	}
+	return text
+}
+
+// loadReconcileSource reads a record snapshot for reconcile: a file written
+// as the cache (or by srcom -save), or "sheet" for the live sheet
+func loadReconcileSource(source string) (map[string][]Record, error) {
+	if source != "sheet" {
+		return readSnapshot(source)
+	}
+	initializeSheets()
+	if err := fetchRecords(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// runReconcile compares two record snapshots from the terminal
+//
+//	scorebot reconcile records.json srcom-records.json
+//	scorebot reconcile -json -game smb2 sheet records.json
+func runReconcile(args []string) {
+	reconcileFlags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	game := reconcileFlags.String("game", "", "Only compare records for this game ("+gameNameList()+")")
+	asJSON := reconcileFlags.Bool("json", false, "Print the report as JSON")
+	reconcileFlags.Parse(args)
+	if reconcileFlags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: reconcile [-game <game>] [-json] (<left> | sheet) (<right> | sheet)")
+		os.Exit(2)
+	}
+	games := commandGames(*game)
+
+	var snapshots []map[string][]Record
+	for _, source := range reconcileFlags.Args() {
+		snapshot, err := loadReconcileSource(source)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error loading records,", err)
+			os.Exit(1)
+		}
+		snapshots = append(snapshots, filterSnapshot(snapshot, games))
+	}
+
+	report := reconcileRecords(reconcileFlags.Arg(0), snapshots[0], reconcileFlags.Arg(1), snapshots[1])
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error writing the report,", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Print(formatReconcileReport(report))
+	}
+	if len(report.Differences) > 0 {
+		os.Exit(1)
+	}
+}
+
+// filterSnapshot returns the sections of a snapshot that belong to the games
+func filterSnapshot(snapshot map[string][]Record, games []string) map[string][]Record {
+	filtered := make(map[string][]Record)
+	for key, section := range snapshot {
+		if len(section) > 0 && hasGame(games, section[0].Game) {
+			filtered[key] = section
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// copyRecords returns a copy of records that can be changed without changing them
+func copyRecords() map[string][]Record {
+	snapshot := make(map[string][]Record)
+	for key, section := range records {
+		snapshot[key] = append([]Record(nil), section...)
+	}
+	return snapshot
+}
+
+// differenceList writes differences as "stage field: left vs right (detail)"
+func differenceList(differences []recordDifference) []string {
+	list := []string{}
+	for _, difference := range differences {
+		line := difference.Stage + " " + difference.Field + ": " + difference.Left + " vs " + difference.Right
+		if difference.Detail != "" {
+			line += " (" + difference.Detail + ")"
+		}
+		list = append(list, line)
+	}
+	return list
+}
+
+func TestReconcileRecords(t *testing.T) {
+	loadFixtureRecords(t)
+
+	tests := []struct {
+		name   string
+		change func(right map[string][]Record)
+		want   []string
+	}{
+		{"same", func(right map[string][]Record) {}, []string{}},
+		{"holder", func(right map[string][]Record) {
+			right["SMB1BeginnerTime"][1].Holder = "Alex"
+		}, []string{"b1 holder: CyclopsDragon vs Alex"}},
+		{"holder case", func(right map[string][]Record) {
+			right["SMB1BeginnerTime"][1].Holder = "cyclopsdragon"
+		}, []string{}},
+		{"faster time", func(right map[string][]Record) {
+			right["SMB1BeginnerTime"][2].Time = "31.50"
+		}, []string{"b2 time: 31.92 vs 31.50 (0.42 faster in right)"}},
+		{"same time written differently", func(right map[string][]Record) {
+			right["SMB1BeginnerTime"][2].Time = "0:31.92"
+		}, []string{}},
+		{"higher score", func(right map[string][]Record) {
+			right["SMB1BeginnerScore"][1].Time = "3,700"
+		}, []string{"b1 score: 3,635 vs 3,700 (65 higher in right)"}},
+		{"video", func(right map[string][]Record) {
+			right["SMB1BeginnerTime"][1].Video = ""
+		}, []string{"b1 video: https://www.twitch.tv/videos/329283573 vs missing"}},
+		{"opened", func(right map[string][]Record) {
+			right["SMB1BeginnerTime"][4].Holder, right["SMB1BeginnerTime"][4].Time = "", ""
+		}, []string{"b4 holder: Ghost Ship vs open"}},
+		// Stages are matched by name, so swapped stages are compared where they moved to
+		{"moved", func(right map[string][]Record) {
+			right["SMB2BeginnerTime"][5], right["SMB2BeginnerTime"][6] = right["SMB2BeginnerTime"][6], right["SMB2BeginnerTime"][5]
+		}, []string{"b5 moved: b5 vs b6", "b6 moved: b6 vs b5"}},
+		{"renamed", func(right map[string][]Record) {
+			right["SMB2BeginnerTime"][8].Name = "Renamed"
+		}, []string{"b8 name: Zig Zag vs Renamed"}},
+		{"stage only on the right", func(right map[string][]Record) {
+			right["SMB1AdvancedTime"] = append(right["SMB1AdvancedTime"], Record{Game: "SMB1", Name: "New Stage", IsTime: true})
+		}, []string{"a31 name: missing vs New Stage"}},
+		// Duplicate story stages aren't compared
+		{"shorter world", func(right map[string][]Record) {
+			right["SMB2Story3Time"] = right["SMB2Story3Time"][:9]
+		}, []string{"s3-10 name: World 3-10 vs missing"}},
+	}
+	for _, test := range tests {
+		right := copyRecords()
+		test.change(right)
+		report := reconcileRecords("left", records, "right", right)
+		if got := differenceList(report.Differences); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReconcileSections(t *testing.T) {
+	loadFixtureRecords(t)
+	right := copyRecords()
+	delete(right, "SMBDMasterTime")
+	right["SMB3BeginnerTime"] = []Record{{Index: 1, Game: "SMB3"}}
+
+	report := reconcileRecords("left", records, "right", right)
+	if !reflect.DeepEqual(report.OnlyLeft, []string{"SMBDMasterTime"}) || !reflect.DeepEqual(report.OnlyRight, []string{"SMB3BeginnerTime"}) {
+		t.Errorf("only left %q and only right %q, want SMBDMasterTime and SMB3BeginnerTime", report.OnlyLeft, report.OnlyRight)
+	}
+	text := formatReconcileReport(report)
+	for _, line := range []string{"Only in left: SMBDMasterTime\n", "Only in right: SMB3BeginnerTime\n"} {
+		if !strings.Contains(text, line) {
+			t.Errorf("report doesn't say %q:\n%s", line, text)
+		}
+	}
+}
+
+func TestReconcileReportJSON(t *testing.T) {
+	loadFixtureRecords(t)
+	right := copyRecords()
+	right["SMB1BeginnerTime"][2].Time = "31.50"
+
+	report := reconcileRecords("left", map[string][]Record{"SMB1BeginnerTime": records["SMB1BeginnerTime"]}, "right", map[string][]Record{"SMB1BeginnerTime": right["SMB1BeginnerTime"]})
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"left":"left","right":"right","compared":10,"differences":[{"key":"SMB1BeginnerTime","game":"SMB1","stage":"b2","name":"Beginner 2","field":"time","left":"31.92","right":"31.50","detail":"0.42 faster in right"}]}`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+}
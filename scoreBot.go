@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
 
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
 	sheets "google.golang.org/api/sheets/v4"
 
 	discordgo "github.com/bwmarrin/discordgo"
@@ -29,13 +34,37 @@ type Record struct {
 	IsTime bool
 }
 
-var retrievingData bool
-
 var testMode bool
 
-var skip int
+// How long to go without a full refresh when checking the sheet for changes fails
+const maxRecordAge = 7200 * time.Second
+
+// Fewest seconds allowed between checks for changes, so the Drive API isn't
+// asked over and over
+const minPollInterval = 10
+
+// Version of the sheet the records were last fetched from, and when
+var lastVersion string
+var lastFetch time.Time
+
+// Only one update talks to the sheet at a time, whether it comes from polling
+// or !update
+var updateLock sync.Mutex
+
+// recordsLock guards everything an update replaces: the records, full game
+// boards, stage rankings, parse errors, known headers, the sheet's version and
+// the Sheets client. Message handlers hold it for reading while they reply.
+var recordsLock sync.RWMutex
 
 var records map[string][]Record
+
+// Longest message Discord allows
+const maxMessageLength = 2000
+
+// Games the bot knows about, in the order they are listed in replies
+var allGames = registryGames()
+
+var discordSession *discordgo.Session
 var conf *jwt.Config
 var client *http.Client
 
@@ -48,6 +77,24 @@ var (
 	sheet           = flag.String("sheet", "", "Sheet ID to read from")
 	sheetFile       = flag.String("sheet-file", "sheet.dat", "Sheet id to read from stored in a file")
 	testModeStr     = flag.String("test", "NO", "Is it in test mode?")
+	cacheFile       = flag.String("cache", "records.json", "File the parsed records are cached in after every update")
+	headersFile     = flag.String("headers", "headers.json", "File the section headers of the last good sheet are saved in")
+	pollInterval    = flag.Int("poll", 60, "Seconds between checks for changes to the sheet")
+	pbsFile         = flag.String("pb-file", "pbs.json", "File personal bests are stored in")
+	submissionsFile = flag.String("submissions", "submissions.json", "File record submissions are stored in")
+	submissionLog   = flag.String("submission-log", "submissions.log", "File approved and rejected submissions are logged in")
+	reviewChannel   = flag.String("review-channel", "", "Discord channel moderators review record submissions in")
+	guildsFile      = flag.String("guild-settings", "guilds.json", "File per server settings are stored in")
+	alertChannel    = flag.String("alert-channel", "", "Discord channel to alert admins in when the sheet layout changes")
+	writeBack       = flag.Bool("write-back", false, "Write approved record submissions into the sheet (needs edit access)")
+	writeDryRun     = flag.Bool("write-dry-run", false, "Log what write-back would change without changing the sheet")
+	writeLog        = flag.String("write-log", "writeback.log", "File every write-back to the sheet is logged in")
+	sheetsEndpoint  = flag.String("sheets-endpoint", "", "Base URL of the Sheets API, to use a server other than Google's")
+	driveEndpoint   = flag.String("drive-endpoint", "", "Base URL of the Drive API, to use a server other than Google's")
+	tokenURL        = flag.String("token-url", google.JWTTokenURL, "URL service account tokens are requested from")
+	fullGameFile    = flag.String("full-game-file", "", "JSON file of full game leaderboards to use alongside the sheet's Full Game tab")
+	rankingsFile    = flag.String("rankings-file", "", "JSON file of the runs behind each record to use alongside the sheet's Rankings tab")
+	speedrunURL     = flag.String("srcom-url", "https://www.speedrun.com/api/v1", "Base URL of the speedrun.com API, to use a server other than speedrun.com")
 	discBotID       string
 )
 
@@ -67,8 +114,8 @@ func initializeSheets() {
 		// The field only supports PEM containers with no passphrase.
 		// The openssl command will convert p12 keys to passphrase-less PEM containers.
 		PrivateKey: []byte(valueOrFileContents("", *privateKeyFile)),
-		Scopes:     []string{sheets.SpreadsheetsReadonlyScope},
-		TokenURL:   google.JWTTokenURL,
+		Scopes:     []string{sheets.SpreadsheetsReadonlyScope, drive.DriveMetadataReadonlyScope},
+		TokenURL:   *tokenURL,
 		// If you would like to impersonate a user, you can
 		// create a transport with a subject. The following GET
 		// request will be made on the behalf of user@example.com.
@@ -77,9 +124,33 @@ func initializeSheets() {
 	}
 	// Initiate an http.Client, the following GET request will be
 	// authorized and authenticated on the behalf of user@example.com.
+	// Writing approved records back needs edit access to the sheet
+	if *writeBack {
+		conf.Scopes[0] = sheets.SpreadsheetsScope
+	}
 	client = conf.Client(oauth2.NoContext)
 }
 
+// newSheetsService creates a Sheets client, talking to sheetsEndpoint
+// instead of Google if it is set
+func newSheetsService() (*sheets.Service, error) {
+	options := []option.ClientOption{option.WithHTTPClient(client)}
+	if *sheetsEndpoint != "" {
+		options = append(options, option.WithEndpoint(*sheetsEndpoint))
+	}
+	return sheets.NewService(context.Background(), options...)
+}
+
+// newDriveService creates a Drive client, talking to driveEndpoint instead
+// of Google if it is set
+func newDriveService() (*drive.Service, error) {
+	options := []option.ClientOption{option.WithHTTPClient(client)}
+	if *driveEndpoint != "" {
+		options = append(options, option.WithEndpoint(*driveEndpoint))
+	}
+	return drive.NewService(context.Background(), options...)
+}
+
 func initializeDiscord() {
 	// Create a new Discord session using the provided login information.
 	dg, err := discordgo.New("", "", valueOrFileContents("", *discToken))
@@ -99,6 +170,8 @@ func initializeDiscord() {
 
 	// Register messageCreate as a callback for the messageCreate events.
 	dg.AddHandler(messageCreate)
+	// Moderators review submissions with reactions
+	dg.AddHandler(messageReactionAdd)
 
 	// Open the websocket and begin listening.
 	err = dg.Open()
@@ -107,6 +180,10 @@ func initializeDiscord() {
 		return
 	}
 
+	// Let the admins know about anything that went wrong while starting up
+	discordSession = dg
+	sendPendingAlerts()
+
 	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
 	// Simple way to keep program running until CTRL-C is pressed.
 	<-make(chan struct{})
@@ -124,13 +201,13 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		whiteListChannel2 = "216643973932908544"
 	}
 
-	// Ignore all messages created by the bot itself or if we are updating
-	if retrievingData || m.Author.ID == discBotID {
+	// Ignore all messages created by the bot itself
+	if m.Author.ID == discBotID {
 		return
 	}
 
-	// Check for channel whiteListChannel1
-	if m.ChannelID != whiteListChannel1 && m.ChannelID != whiteListChannel2 {
+	// Check for channel whiteListChannel1 (moderators can also use the review channel)
+	if m.ChannelID != whiteListChannel1 && m.ChannelID != whiteListChannel2 && m.ChannelID != *reviewChannel {
 		return
 	}
 
@@ -145,14 +222,155 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	} else if message == "!data" {
 		_, _ = s.ChannelMessageSend(m.ChannelID, "IL Data: https://docs.google.com/spreadsheets/d/"+valueOrFileContents(*sheet, *sheetFile)+"/")
 		return
-	} else if message == "!update" && ((m.Author.Username == "Alex" && m.Author.Discriminator == "1806") || (m.Author.Username == "CyclopsDragon" && m.Author.Discriminator == "8762") || (m.Author.Username == "bobjrsenior" && m.Author.Discriminator == "8628")) {
+	} else if message == "!update" && isAdmin(m.Author) {
 		_, _ = s.ChannelMessageSend(m.ChannelID, "Updating")
-		skip++
-		initializeSheets()
 		updateInformation()
 		return
 	}
 
+	// Replies wait for an update that is swapping in new records
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+
+	if strings.HasPrefix(message, "!links ") && isAdmin(m.Author) {
+		_, _ = s.ChannelMessageSend(m.ChannelID, setVideoEmbeds(m.GuildID, strings.TrimPrefix(message, "!links ")))
+		return
+	} else if isReviewCommand(message) && isAdmin(m.Author) {
+		_, _ = s.ChannelMessageSend(m.ChannelID, reviewCommand(message, m.Author))
+		return
+	} else if (message == "!export" || strings.HasPrefix(message, "!export ")) && isAdmin(m.Author) {
+		sendExport(s, m)
+		return
+	} else if message == "!pending" && isAdmin(m.Author) {
+		sendReply(s, m, listPendingSubmissions())
+		return
+	} else if message == "!submit" || strings.HasPrefix(message, "!submit ") {
+		_, _ = s.ChannelMessageSend(m.ChannelID, submitRecord(message, m.Author, m.ChannelID))
+		return
+	} else if message == "!parse-errors" && isAdmin(m.Author) {
+		if len(parseErrors) == 0 {
+			_, _ = s.ChannelMessageSend(m.ChannelID, "No parse errors in the last update")
+		} else {
+			_, _ = s.ChannelMessageSend(m.ChannelID, formatIssues("Parse errors in the last update:\n", parseErrors))
+		}
+		return
+	}
+
+	// Personal bests belong to whoever sent the message
+	if returnMessage := buildPBReply(message, m.Author); returnMessage != "" {
+		sendReply(s, m, returnMessage)
+		return
+	}
+
+	// Build the reply for a record query
+	sendReply(s, m, buildReply(message, allGames))
+}
+
+// sendReply sends a reply in the channel the message came from, split up if
+// it is too long and with video links embedded if the server wants them
+func sendReply(s *discordgo.Session, m *discordgo.MessageCreate, returnMessage string) {
+	if getGuildSettings(m.GuildID).EmbedVideos {
+		returnMessage = embedLinks(returnMessage)
+	}
+	for _, part := range splitMessage(returnMessage) {
+		_, _ = s.ChannelMessageSend(m.ChannelID, part)
+	}
+}
+
+// splitMessage breaks text into parts short enough for Discord, splitting
+// between lines (or between words for lines that are too long by themselves)
+func splitMessage(text string) []string {
+	var parts []string
+	part := ""
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > maxMessageLength {
+			cut := strings.LastIndex(line[:maxMessageLength], " ") + 1
+			if cut == 0 {
+				cut = maxMessageLength
+			}
+			if part != "" {
+				parts = append(parts, part)
+				part = ""
+			}
+			parts = append(parts, line[:cut])
+			line = line[cut:]
+		}
+		if len(part)+len(line) > maxMessageLength {
+			parts = append(parts, part)
+			part = ""
+		}
+		part += line
+	}
+	if strings.TrimSpace(part) != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// isAdmin reports whether the user is allowed to use admin commands
+func isAdmin(user *discordgo.User) bool {
+	return (user.Username == "Alex" && user.Discriminator == "1806") || (user.Username == "CyclopsDragon" && user.Discriminator == "8762") || (user.Username == "bobjrsenior" && user.Discriminator == "8628")
+}
+
+// buildReply returns what the bot would say in response to a record query such
+// as "!b10" or "!s3-7", limited to the given games. An empty string means the
+// message is not a record query and gets no reply.
+func buildReply(message string, games []string) string {
+	// The games the bot knows about
+	if message == "!games" {
+		return buildGamesReply(games)
+	}
+
+	// Whole story worlds at once
+	if message == "!world" || strings.HasPrefix(message, "!world ") {
+		return buildWorldReply(message, games)
+	}
+
+	// The same stage in every game it appears in
+	if message == "!compare" || strings.HasPrefix(message, "!compare ") {
+		return buildCompareReply(message, games)
+	}
+
+	// Random stages for practice and races
+	if message == "!random" || strings.HasPrefix(message, "!random ") {
+		return buildRandomReply(message, games)
+	}
+
+	// Records that are missing proof
+	if message == "!novideo" || strings.HasPrefix(message, "!novideo ") {
+		return buildNoVideoReply(message, games)
+	}
+
+	// Records nobody holds or that have no video
+	if message == "!open" || strings.HasPrefix(message, "!open ") {
+		return buildOpenReply(message, games)
+	}
+
+	// Full game leaderboards
+	if message == "!fg" || strings.HasPrefix(message, "!fg ") {
+		return buildFullGameReply(message, games)
+	}
+
+	// Sum of best for a difficulty or story world
+	if message == "!sob" || strings.HasPrefix(message, "!sob ") {
+		return buildSobReply(message, games)
+	}
+
+	// The best runs of a stage, not just the record
+	if isTopQuery(message) {
+		return buildTopReply(message, games)
+	}
+
+	// One game's stage, possibly in one of its variants
+	if isGameQuery(message) {
+		return buildGameStageReply(message, games)
+	}
+
+	// Story stages have a few different ways of being asked for
+	if isStoryQuery(message) {
+		return buildStoryReply(message, games)
+	}
+
 	// Where we are in the message
 	index := 0
 
@@ -160,430 +378,372 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if len(message) >= 3 && message[index] == '!' {
 		index++
 
-		isStory := false
-
 		// Retrieve the difficulty
-		difficulty := ""
-		if message[index] == 'b' {
-			difficulty = "Beginner"
-		} else if message[index] == 'a' {
-			difficulty = "Advanced"
-		} else if message[index] == 'e' {
-			difficulty = "Expert"
-		} else if message[index] == 'm' {
-			difficulty = "Master"
-		} else if message[index] == 's' {
-			difficulty = "Story"
-			isStory = true
-		} else {
-			return
+		found := findDifficulty(message[index : index+1])
+		if found == nil {
+			return ""
 		}
+		difficulty := found.Name
 		index++
 
 		// Check if it is an Extra stage
-		if !isStory && message[index] == 'x' {
+		if message[index] == 'x' {
 			index++
 			if len(message) == 3 {
-				return
+				return ""
 			}
 			difficulty += "Extra"
 		}
-		var level int
-		var world string
-		var err error
-
-		if !isStory {
-			// Get the level requested and convert it into an int
-			levelString := message[index:len(message)]
-			level, err = strconv.Atoi(levelString)
-			if err != nil {
-				return
-			}
-		} else {
-			if len(message) < 5 {
-				return
-			}
-			if message[index+1] == '-' {
-				world = message[index:(index + 1)]
-				index += 2
-			} else {
-				if len(message) < 6 {
-					return
-				}
-				world = message[index:(index + 2)]
-				index += 3
-			}
-			levelString := message[index:len(message)]
-			level, err = strconv.Atoi(levelString)
-			if err != nil {
-				return
-			}
+
+		// Get the level requested and convert it into an int
+		levelString := message[index:len(message)]
+		level, err := strconv.Atoi(levelString)
+		if err != nil {
+			return ""
 		}
+
 		// Start building the return message
 		returnMessage := ""
 
-		if !isStory {
-			// Get the formatted record holders for smb1
-			smb1Time := retrieveRecordString("SMB1", difficulty, "Time", level)
-			smb1Score := retrieveRecordString("SMB1", difficulty, "Score", level)
+		for _, game := range games {
+			// Get the formatted record holders for the game
+			gameTime := retrieveRecordString(game, difficulty, "Time", level)
+			gameScore := retrieveRecordString(game, difficulty, "Score", level)
 			// If a record exists
-			if smb1Time != "" {
-				// Add smb1 information to the return message
-				smb1Name := "SMB1 " + getLevelName("SMB1", difficulty, "Time", level)
-				returnMessage += smb1Name + ": " + smb1Time + ", " + smb1Score + "\n"
-			}
-
-			// Get the formatted record holders for smb2
-			smb2Time := retrieveRecordString("SMB2", difficulty, "Time", level)
-			smb2Score := retrieveRecordString("SMB2", difficulty, "Score", level)
-			// If a record exists
-			if smb2Time != "" {
-				// Add smb2 information to the return message
-				smb2Name := "SMB2 " + getLevelName("SMB2", difficulty, "Time", level)
-				returnMessage += smb2Name + ": " + smb2Time + ", " + smb2Score + "\n"
-			}
-
-			// Get the formatted record holders for smbd
-			smbDTime := retrieveRecordString("SMBD", difficulty, "Time", level)
-			smbDScore := retrieveRecordString("SMBD", difficulty, "Score", level)
-			// If a record exists
-			if smbDTime != "" {
-				// Add smbd information to the return message
-				smbDName := "SMBDX " + getLevelName("SMBD", difficulty, "Time", level)
-				returnMessage += smbDName + ": " + smbDTime + ", " + smbDScore + "\n"
-			}
-		} else {
-			// Get the formatted record holders for smb2
-			smb2StoryTime := retrieveRecordStoryString("SMB2", difficulty, "Time", world, level)
-			smb2StoryScore := retrieveRecordStoryString("SMB2", difficulty, "Score", world, level)
-			// If a record exists
-			if smb2StoryTime != "" {
-				// Add smb2 information to the return message
-				smb2Name := "SMB2 " + getStoryLevelName("SMB2", difficulty, "Time", world, level)
-				if smb2StoryTime == "Duplicate Stage" {
-					returnMessage += smb2Name + ": " + smb2StoryTime + "\n"
-				} else {
-					returnMessage += smb2Name + ": " + smb2StoryTime + ", " + smb2StoryScore + "\n"
-				}
-			}
-			// Get the formatted record holders for smbd
-			smbdStoryTime := retrieveRecordStoryString("SMBD", difficulty, "Time", world, level)
-			smbdStoryScore := retrieveRecordStoryString("SMBD", difficulty, "Score", world, level)
-			// If a record exists
-			if smbdStoryTime != "" {
-				// Add smb2 information to the return message
-				smbdName := "SMBDX " + getStoryLevelName("SMBD", difficulty, "Time", world, level)
-				if smbdStoryTime == "Duplicate Stage" {
-					returnMessage += smbdName + ": " + smbdStoryTime + "\n"
-				} else {
-					returnMessage += smbdName + ": " + smbdStoryTime + ", " + smbdStoryScore + "\n"
-				}
+			if gameTime != "" {
+				// Add the game's information to the return message
+				gameName := gameDisplayName(game) + " " + getLevelName(game, difficulty, "Time", level)
+				returnMessage += gameName + ": " + gameTime + ", " + gameScore + "\n"
 			}
 		}
-		_, _ = s.ChannelMessageSend(m.ChannelID, returnMessage)
+		return returnMessage
+	}
+	return ""
+}
 
+// hasGame reports whether game is in the list of games
+func hasGame(games []string, game string) bool {
+	for _, g := range games {
+		if g == game {
+			return true
+		}
 	}
+	return false
 }
 
 func main() {
 	flag.Parse()
+
+	// Subcommands run once from the terminal instead of starting the bot
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "query":
+			runQuery(flag.Args()[1:])
+		case "novideo":
+			runNoVideo(flag.Args()[1:])
+		case "srcom":
+			runSpeedrun(flag.Args()[1:])
+		case "reconcile":
+			runReconcile(flag.Args()[1:])
+		case "export":
+			runExport(flag.Args()[1:])
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+			os.Exit(2)
+		}
+		return
+	}
+
+	if *pollInterval < minPollInterval {
+		fmt.Fprintf(os.Stderr, "-poll must be at least %d seconds\n", minPollInterval)
+		os.Exit(2)
+	}
+
 	if *testModeStr != "NO" {
 		testMode = true
 	}
-	retrievingData = false
-	skip = 0
+	err := loadGuildSettings()
+	if err != nil {
+		fmt.Println("error loading guild settings,", err)
+	}
+	err = loadPersonalBests()
+	if err != nil {
+		fmt.Println("error loading personal bests,", err)
+	}
+	err = loadSubmissions()
+	if err != nil {
+		fmt.Println("error loading submissions,", err)
+	}
+	err = loadFullGameFile()
+	if err != nil {
+		fmt.Println("error loading full game leaderboards,", err)
+	}
+	err = loadRankingsFile()
+	if err != nil {
+		fmt.Println("error loading stage rankings,", err)
+	}
+	err = loadKnownHeaders()
+	if err != nil {
+		fmt.Println("error loading sheet headers,", err)
+	}
 
-	// Initialize google sheets connected
-	initializeSheets()
 	// Retrieve Information form the sheet
 	updateInformation()
 
+	// Keep an eye on the sheet for new records
+	go pollForChanges(time.Duration(*pollInterval) * time.Second)
+
 	// Connect to discord
 	initializeDiscord()
 }
 
+// updateInformation connects to the sheet and fetches the records, falling
+// back to the cache if there are none yet
 func updateInformation() {
-	retrievingData = true
-	svc, err := sheets.New(client)
-	if err != nil {
-		log.Fatalf("Unable to create Sheets service: %v", err)
-	}
+	updateLock.Lock()
+	defer updateLock.Unlock()
 
-	// Call for the SMB IL Spreadsheet
-	getCall := svc.Spreadsheets.Get(valueOrFileContents(*sheet, *sheetFile))
-	if getCall == nil {
-		fmt.Print("Error")
-		return
-	}
-	//Get the data from it
-	getCall = getCall.IncludeGridData(true)
-	if getCall == nil {
-		fmt.Print("Error")
-		return
-	}
-	// Execute request
-	spreadsheet, err := getCall.Do()
+	recordsLock.Lock()
+	initializeSheets()
+	recordsLock.Unlock()
+
+	err := fetchRecords()
 	if err != nil {
-		fmt.Print("Error Executing Query")
-		log.Fatal(err)
-		return
+		fmt.Println("error updating records,", err)
+		// Fall back to the last good records if there is nothing loaded yet
+		if records == nil {
+			recordsLock.Lock()
+			err = loadSnapshot(*cacheFile)
+			recordsLock.Unlock()
+			if err != nil {
+				fmt.Print("Error Executing Query")
+				log.Fatal(err)
+				return
+			}
+		}
+	} else {
+		// Keep a copy around for offline queries
+		err = saveSnapshot(*cacheFile)
+		if err != nil {
+			fmt.Println("error saving record cache,", err)
+		}
 	}
+}
 
+// parseSpreadsheet reads every section of sheetLayout out of the spreadsheet,
+// skipping the ones in broken. Sections that can't be parsed are left out and
+// their problems returned.
+func parseSpreadsheet(spreadsheet *sheets.Spreadsheet, broken map[string]bool) (map[string][]Record, []sheetIssue) {
 	// Initialize map for records
-	records = make(map[string][]Record)
+	store := make(map[string][]Record)
+	var issues []sheetIssue
 
 	// Go through every sheet and the data in each sheet
 	for _, sheet := range spreadsheet.Sheets {
+		// Each tab has its data in different places/a different category
+		tab := findTabLayout(sheet.Properties.Title)
+		if tab == nil {
+			continue
+		}
 		for _, data := range sheet.Data {
-			// Parse each sheet differently (data is in different places/a different category
-			switch sheet.Properties.Title {
-			case "SMB1 Time":
-				parseSMB1Time(data)
-				break
-			case "SMB1 Score":
-				parseSMB1Score(data)
-				break
-			case "SMB2 Challenge Time":
-				parseSMB2Time(data)
-				break
-			case "SMB2 Challenge Score":
-				parseSMB2Score(data)
-				break
-			case "SMB2 Story":
-				parseSMB2Story(data)
-				break
-			case "SMBDX Challenge Time":
-				parseSMBDTime(data)
-				break
-			case "SMBDX Challenge Score":
-				parseSMBDScore(data)
-				break
-			case "SMBDX Story":
-				parseSMBDStory(data)
-				break
+			for _, sec := range tab.Sections {
+				if broken[sec.Key] {
+					continue
+				}
+				issues = append(issues, parseSection(store, data.RowData, tab.Title, sec)...)
 			}
 		}
 	}
-
-	retrievingData = false
-
-	// Wait for a bit, then update the information
-	go timeOut(7200)
-
+	return store, issues
 }
 
-func timeOut(waitInSeconds time.Duration) {
-	time.Sleep(waitInSeconds * time.Second)
-	if !retrievingData {
-		initializeSheets()
-		updateInformation()
-	} else if skip != 0 {
-		go timeOut(7200)
-	} else {
-		skip--
+// fetchRecords downloads the IL spreadsheet and parses it into records
+func fetchRecords() error {
+	// Note the version first so edits made while fetching are picked up next time
+	version, err := sheetVersion()
+	if err != nil {
+		fmt.Println("error checking the sheet version,", err)
 	}
-}
-
-func parseSMB1Time(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMB1BeginnerTime", "SMB1", 3, 2, 10, true)
-	parseSection(rowData, "SMB1BeginnerExtraTime", "SMB1", 18, 2, 3, true)
-	parseSection(rowData, "SMB1BeginnerTimeAlt", "SMB1", 7, 22, 1, true)
-
-	parseSection(rowData, "SMB1AdvancedTime", "SMB1", 3, 7, 30, true)
-	parseSection(rowData, "SMB1AdvancedExtraTime", "SMB1", 38, 7, 5, true)
-	parseSection(rowData, "SMB1AdvancedTimeAlt", "SMB1", 13, 22, 5, true)
-
-	parseSection(rowData, "SMB1ExpertTime", "SMB1", 3, 12, 50, true)
-	parseSection(rowData, "SMB1ExpertExtraTime", "SMB1", 58, 12, 10, true)
-	parseSection(rowData, "SMB1ExpertTimeAlt", "SMB1", 24, 22, 5, true)
-
-	parseSection(rowData, "SMB1MasterTime", "SMB1", 3, 17, 10, true)
-}
-
-func parseSMB1Score(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMB1BeginnerScore", "SMB1", 3, 2, 10, false)
-	parseSection(rowData, "SMB1BeginnerExtraScore", "SMB1", 18, 2, 3, false)
-
-	parseSection(rowData, "SMB1AdvancedScore", "SMB1", 3, 7, 30, false)
-	parseSection(rowData, "SMB1AdvancedExtraScore", "SMB1", 38, 7, 5, false)
-
-	parseSection(rowData, "SMB1ExpertScore", "SMB1", 3, 12, 50, false)
-	parseSection(rowData, "SMB1ExpertExtraScore", "SMB1", 58, 12, 10, false)
-
-	parseSection(rowData, "SMB1MasterScore", "SMB1", 3, 17, 10, false)
-}
 
-// Finish Alts
-func parseSMB2Time(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMB2BeginnerTime", "SMB2", 3, 2, 10, true)
-	parseSection(rowData, "SMB2BeginnerExtraTime", "SMB2", 18, 2, 10, true)
+	svc, err := newSheetsService()
+	if err != nil {
+		return fmt.Errorf("unable to create Sheets service: %v", err)
+	}
 
-	parseSection(rowData, "SMB2AdvancedTime", "SMB2", 3, 7, 30, true)
-	parseSection(rowData, "SMB2AdvancedExtraTime", "SMB2", 38, 7, 10, true)
+	// Find out which tabs the sheet has
+	sheetID := valueOrFileContents(*sheet, *sheetFile)
+	tabs, err := svc.Spreadsheets.Get(sheetID).Fields(tabFields).Do()
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, tab := range tabs.Sheets {
+		present[tab.Properties.Title] = true
+	}
 
-	parseSection(rowData, "SMB2ExpertTime", "SMB2", 3, 12, 50, true)
-	parseSection(rowData, "SMB2ExpertExtraTime", "SMB2", 58, 12, 10, true)
+	// Call for the SMB IL Spreadsheet
+	getCall := svc.Spreadsheets.Get(sheetID)
+	// Only get the cells the sections live in, not the whole sheet
+	getCall = getCall.Ranges(fetchRanges(present)...).IncludeGridData(true).Fields(sectionFields)
+	// Execute request
+	spreadsheet, err := getCall.Do()
+	if err != nil {
+		return err
+	}
 
-	parseSection(rowData, "SMB2MasterTime", "SMB2", 3, 17, 10, true)
-	parseSection(rowData, "SMB2MasterExtraTime", "SMB2", 18, 17, 10, true)
-}
+	// Put the ranges back together the way the parser expects them
+	for _, sheet := range spreadsheet.Sheets {
+		if findTabLayout(sheet.Properties.Title) != nil {
+			flattenGridData(sheet)
+		}
+	}
 
-func parseSMB2Score(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
+	// Sections that don't look the way we expect aren't parsed at all
+	issues := validateSpreadsheet(spreadsheet)
+	broken := brokenSections(issues)
+	store, parseIssues := parseSpreadsheet(spreadsheet, broken)
+	issues = append(issues, parseIssues...)
+	broken = brokenSections(issues)
+
+	// Full game runs are in their own tab, if the sheet has one
+	var boards map[string]*fullGameBoard
+	if fullGameSheet := findSheet(spreadsheet, fullGameTab); fullGameSheet != nil {
+		var boardIssues []sheetIssue
+		boards, boardIssues = parseFullGameTab(fullGameSheet)
+		issues = append(issues, boardIssues...)
+	}
 
-	parseSection(rowData, "SMB2BeginnerScore", "SMB2", 3, 2, 10, false)
-	parseSection(rowData, "SMB2BeginnerExtraScore", "SMB2", 18, 2, 10, false)
+	// As are the runs behind each record
+	var rankings map[string][]rankedRun
+	if rankingsSheet := findSheet(spreadsheet, rankingsTab); rankingsSheet != nil {
+		var rankingIssues []sheetIssue
+		rankings, rankingIssues = parseRankingsTab(rankingsSheet)
+		issues = append(issues, rankingIssues...)
+	}
 
-	parseSection(rowData, "SMB2AdvancedScore", "SMB2", 3, 7, 30, false)
-	parseSection(rowData, "SMB2AdvancedExtraScore", "SMB2", 38, 7, 10, false)
+	if len(issues) > 0 {
+		reportIssues(issues)
+	}
 
-	parseSection(rowData, "SMB2ExpertScore", "SMB2", 3, 12, 50, false)
-	parseSection(rowData, "SMB2ExpertExtraScore", "SMB2", 58, 12, 10, false)
+	// Everything from here on is seen by the message handlers
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	parseErrors = issues
+	if len(store) == 0 {
+		return fmt.Errorf("no sections could be read from the sheet")
+	}
+	rememberHeaders(spreadsheet, broken)
+	err = writeJSONFile(*headersFile, knownHeaders)
+	if err != nil {
+		fmt.Println("error saving sheet headers,", err)
+	}
 
-	parseSection(rowData, "SMB2MasterScore", "SMB2", 3, 17, 10, false)
-	parseSection(rowData, "SMB2MasterExtraScore", "SMB2", 18, 17, 10, false)
+	// Keep the last good copy of any section that couldn't be read
+	previous := records
+	if previous == nil {
+		previous, _ = readSnapshot(*cacheFile)
+	}
+	for mapKey := range broken {
+		if old, ok := previous[mapKey]; ok {
+			store[mapKey] = old
+		}
+	}
 
+	// Only replace the records once the whole sheet is parsed
+	records = store
+	setFullGameBoards(boards)
+	setStageRankings(rankings)
+	lastVersion = version
+	lastFetch = time.Now()
+	return nil
 }
 
-func parseSMB2Story(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMB2Story1Time", "SMB2", 3, 2, 10, true)
-	parseSection(rowData, "SMB2Story1Score", "SMB2", 3, 7, 10, false)
-
-	parseSection(rowData, "SMB2Story2Time", "SMB2", 3, 12, 10, true)
-	parseSection(rowData, "SMB2Story2Score", "SMB2", 3, 17, 10, false)
-
-	parseSection(rowData, "SMB2Story3Time", "SMB2", 16, 2, 10, true)
-	parseSection(rowData, "SMB2Story3Score", "SMB2", 16, 7, 10, false)
-
-	parseSection(rowData, "SMB2Story4Time", "SMB2", 16, 12, 10, true)
-	parseSection(rowData, "SMB2Story4Score", "SMB2", 16, 17, 10, false)
-
-	parseSection(rowData, "SMB2Story5Time", "SMB2", 29, 2, 10, true)
-	parseSection(rowData, "SMB2Story5Score", "SMB2", 29, 7, 10, false)
-
-	parseSection(rowData, "SMB2Story6Time", "SMB2", 29, 12, 10, true)
-	parseSection(rowData, "SMB2Story6Score", "SMB2", 29, 17, 10, false)
-
-	parseSection(rowData, "SMB2Story7Time", "SMB2", 42, 2, 10, true)
-	parseSection(rowData, "SMB2Story7Score", "SMB2", 42, 7, 10, false)
-
-	parseSection(rowData, "SMB2Story8Time", "SMB2", 42, 12, 10, true)
-	parseSection(rowData, "SMB2Story8Score", "SMB2", 42, 17, 10, false)
-
-	parseSection(rowData, "SMB2Story9Time", "SMB2", 55, 2, 10, true)
-	parseSection(rowData, "SMB2Story9Score", "SMB2", 55, 7, 10, false)
-
-	parseSection(rowData, "SMB2Story10Time", "SMB2", 55, 12, 10, true)
-	parseSection(rowData, "SMB2Story10Score", "SMB2", 55, 17, 10, false)
-
+// pollForChanges checks every interval whether the sheet has been edited and
+// updates the records when it has
+func pollForChanges(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if sheetChanged() {
+			updateInformation()
+		}
+	}
 }
 
-// Finish Alts
-func parseSMBDTime(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMBDBeginnerTime", "SMBD", 3, 2, 40, true)
-	parseSection(rowData, "SMBDBeginnerExtraTime", "SMBD", 48, 2, 20, true)
-
-	parseSection(rowData, "SMBDAdvancedTime", "SMBD", 3, 7, 70, true)
-	parseSection(rowData, "SMBDAdvancedExtraTime", "SMBD", 78, 7, 20, true)
-
-	parseSection(rowData, "SMBDExpertTime", "SMBD", 3, 12, 100, true)
-	parseSection(rowData, "SMBDExpertExtraTime", "SMBD", 108, 12, 20, true)
+// sheetChanged reports whether the sheet was edited since the records were
+// fetched, or whether it is time to fetch them anyway
+func sheetChanged() bool {
+	updateLock.Lock()
+	defer updateLock.Unlock()
 
-	parseSection(rowData, "SMBDMasterTime", "SMBD", 3, 17, 12, true)
-	parseSection(rowData, "SMBDMasterExtraTime", "SMBD", 28, 17, 10, true)
+	version, err := sheetVersion()
+	if err != nil {
+		fmt.Println("error checking the sheet for changes,", err)
+		// Still update every so often if the check keeps failing
+		return time.Since(lastFetch) >= maxRecordAge
+	}
+	return version != lastVersion
 }
 
-func parseSMBDScore(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMBDBeginnerScore", "SMBD", 3, 2, 40, false)
-	parseSection(rowData, "SMBDBeginnerExtraScore", "SMBD", 48, 2, 20, false)
-
-	parseSection(rowData, "SMBDAdvancedScore", "SMBD", 3, 7, 70, false)
-	parseSection(rowData, "SMBDAdvancedExtraScore", "SMBD", 78, 7, 20, false)
-
-	parseSection(rowData, "SMBDExpertScore", "SMBD", 3, 12, 100, false)
-	parseSection(rowData, "SMBDExpertExtraScore", "SMBD", 108, 12, 20, false)
+// sheetVersion asks Drive for the sheet's version, which changes every time
+// the sheet is edited. This is much cheaper than fetching the sheet itself.
+func sheetVersion() (string, error) {
+	svc, err := newDriveService()
+	if err != nil {
+		return "", fmt.Errorf("unable to create Drive service: %v", err)
+	}
 
-	parseSection(rowData, "SMBDMasterScore", "SMBD", 3, 17, 12, false)
-	parseSection(rowData, "SMBDMasterExtraScore", "SMBD", 28, 17, 10, false)
+	file, err := svc.Files.Get(valueOrFileContents(*sheet, *sheetFile)).Fields("version,modifiedTime").Do()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(file.Version, 10) + " " + file.ModifiedTime, nil
 }
 
-func parseSMBDStory(data *sheets.GridData) {
-	//Store the row data and the key for this category
-	rowData := data.RowData
-
-	parseSection(rowData, "SMBDStory1Time", "SMBD", 3, 2, 20, true)
-	parseSection(rowData, "SMBDStory1Score", "SMBD", 3, 7, 20, false)
+// parseSection copies one section of a tab into store. If the section can't be
+// read it is left out of store and the problems are returned instead.
+func parseSection(store map[string][]Record, rowData []*sheets.RowData, tab string, sec section) (issues []sheetIssue) {
+	mapKey := sec.Key
 
-	parseSection(rowData, "SMBDStory2Time", "SMBD", 3, 12, 20, true)
-	parseSection(rowData, "SMBDStory2Score", "SMBD", 3, 17, 20, false)
-
-	parseSection(rowData, "SMBDStory3Time", "SMBD", 26, 2, 20, true)
-	parseSection(rowData, "SMBDStory3Score", "SMBD", 26, 7, 20, false)
-
-	parseSection(rowData, "SMBDStory4Time", "SMBD", 26, 12, 20, true)
-	parseSection(rowData, "SMBDStory4Score", "SMBD", 26, 17, 20, false)
-
-	parseSection(rowData, "SMBDStory5Time", "SMBD", 49, 2, 20, true)
-	parseSection(rowData, "SMBDStory5Score", "SMBD", 49, 7, 20, false)
-
-	parseSection(rowData, "SMBDStory6Time", "SMBD", 49, 12, 20, true)
-	parseSection(rowData, "SMBDStory6Score", "SMBD", 49, 17, 20, false)
-
-	parseSection(rowData, "SMBDStory7Time", "SMBD", 72, 2, 20, true)
-	parseSection(rowData, "SMBDStory7Score", "SMBD", 72, 7, 20, false)
-
-	parseSection(rowData, "SMBDStory8Time", "SMBD", 72, 12, 20, true)
-	parseSection(rowData, "SMBDStory8Score", "SMBD", 72, 17, 20, false)
-
-	parseSection(rowData, "SMBDStory9Time", "SMBD", 95, 2, 20, true)
-	parseSection(rowData, "SMBDStory9Score", "SMBD", 95, 7, 20, false)
-
-	parseSection(rowData, "SMBDStory10Time", "SMBD", 95, 12, 20, true)
-	parseSection(rowData, "SMBDStory10Score", "SMBD", 95, 17, 20, false)
-
-}
+	// A bad row should only cost us this section, not the whole bot
+	defer func() {
+		if r := recover(); r != nil {
+			issues = append(issues, sheetIssue{Tab: tab, Section: mapKey, Row: -1, Column: -1, Reason: fmt.Sprint("parser crashed: ", r)})
+		}
+		if len(issues) > 0 {
+			delete(store, mapKey)
+		}
+	}()
 
-func parseSection(rowData []*sheets.RowData, mapKey string, game string, startRow int, startCol int, amount int, isTime bool) {
 	// Find the last row
-	endRow := startRow + amount
+	endRow := sec.StartRow + sec.Amount
 
 	// Initialize the value in the map
-	records[mapKey] = make([]Record, 0, amount)
-	records[mapKey] = append(records[mapKey], Record{Index: 1, Game: game, Name: "", Holder: "", Time: "", Video: "", IsTime: isTime})
+	store[mapKey] = make([]Record, 0, sec.Amount)
+	store[mapKey] = append(store[mapKey], Record{Index: 1, Game: sec.Game, Name: "", Holder: "", Time: "", Video: "", IsTime: sec.IsTime})
 	currentIndex := 1
 
 	// Copy all the data into it
-	for i := startRow; i < endRow; i++ {
-		name := rowData[i].Values[startCol].FormattedValue
-		time := rowData[i].Values[startCol+1].FormattedValue
-		video := rowData[i].Values[startCol+1].Hyperlink
-		holder := rowData[i].Values[startCol+2].FormattedValue
+	for i := sec.StartRow; i < endRow; i++ {
+		nameCell := cellAt(rowData, i, sec.StartCol)
+		timeCell := cellAt(rowData, i, sec.StartCol+1)
+		holderCell := cellAt(rowData, i, sec.StartCol+2)
+		if nameCell == nil || timeCell == nil || holderCell == nil {
+			issues = append(issues, sheetIssue{Tab: tab, Section: mapKey, Row: i, Column: -1, Reason: "row is too short"})
+			continue
+		}
+
+		name := nameCell.FormattedValue
+		time := timeCell.FormattedValue
+		video := timeCell.Hyperlink
+		holder := holderCell.FormattedValue
 
-		records[mapKey] = append(records[mapKey], Record{Index: currentIndex, Game: game, Name: name, Holder: holder, Time: time, Video: video, IsTime: isTime})
+		store[mapKey] = append(store[mapKey], Record{Index: currentIndex, Game: sec.Game, Name: name, Holder: holder, Time: time, Video: video, IsTime: sec.IsTime})
 
 		currentIndex++
 	}
 	// Update the level count
-	records[mapKey][0].Index = currentIndex
+	store[mapKey][0].Index = currentIndex
+	return issues
 }
 
 func retrieveRecordString(game string, difficulty string, scoreType string, level int) string {
@@ -610,7 +770,7 @@ func retrieveRecordString(game string, difficulty string, scoreType string, leve
 
 			// Only add a video spot if there is a video
 			if record.Video != "" {
-				return scoreType + ": " + time + " (" + holder + ") (<" + record.Video + ">)"
+				return scoreType + ": " + time + " (" + holder + ") (" + formatVideo(record.Video) + ")"
 			} else {
 				return scoreType + ": " + time + " (" + holder + ")"
 			}
@@ -648,7 +808,7 @@ func retrieveRecordStoryString(game string, difficulty string, scoreType string,
 
 			// Only add a video spot if there is a video
 			if record.Video != "" {
-				return scoreType + ": " + time + " (" + holder + ") (<" + record.Video + ">)"
+				return scoreType + ": " + time + " (" + holder + ") (" + formatVideo(record.Video) + ")"
 			} else {
 				return scoreType + ": " + time + " (" + holder + ")"
 			}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// guildSettings are the options each Discord server can choose for itself
+type guildSettings struct {
+	// Let Discord embed video links instead of suppressing them
+	EmbedVideos bool `json:"embedVideos"`
+}
+
+// Settings for every server that has changed them, by guild id
+var guilds = make(map[string]guildSettings)
+var guildsLock sync.Mutex
+
+// loadGuildSettings reads the server settings from guildsFile. A missing file
+// just means no server has changed its settings yet.
+func loadGuildSettings() error {
+	data, err := ioutil.ReadFile(*guildsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	guildsLock.Lock()
+	defer guildsLock.Unlock()
+	return json.Unmarshal(data, &guilds)
+}
+
+// getGuildSettings returns the settings of a server
+func getGuildSettings(guildID string) guildSettings {
+	guildsLock.Lock()
+	defer guildsLock.Unlock()
+	return guilds[guildID]
+}
+
+// setVideoEmbeds handles "!links embed" and "!links suppress", returning the reply
+func setVideoEmbeds(guildID string, choice string) string {
+	guildsLock.Lock()
+	defer guildsLock.Unlock()
+
+	settings := guilds[guildID]
+	switch strings.TrimSpace(strings.ToLower(choice)) {
+	case "embed":
+		settings.EmbedVideos = true
+	case "suppress":
+		settings.EmbedVideos = false
+	default:
+		return "Usage: !links (embed|suppress)"
+	}
+	guilds[guildID] = settings
+
+	err := writeJSONFile(*guildsFile, guilds)
+	if err != nil {
+		return "Changed for now, but the setting couldn't be saved: " + err.Error()
+	}
+	if settings.EmbedVideos {
+		return "Video links will be embedded"
+	}
+	return "Video links will not be embedded"
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reply for a sum of best query that can't be understood
+const sobUsage = "Usage: !sob <game> <difficulty|world> [score] (ex: !sob smb2 expert, !sob smb1 bx, !sob smbdx 3)"
+
+// What an unclaimed time counts as, the same as retrieveRecordString shows
+const unclaimedTime = 60.0
+
+// holderShare is how much of a section's total one holder is responsible for
+type holderShare struct {
+	Holder string
+	Stages int
+	Total  float64
+}
+
+// sectionSum adds up every record in a section. Totals are in seconds for
+// times and in points for scores.
+type sectionSum struct {
+	Total     float64
+	Counted   int
+	Unclaimed int
+	Holders   []holderShare
+}
+
+// sumSection adds up the records of a section, skipping duplicate stages and
+// counting stages without a holder as unclaimed
+func sumSection(section []Record) sectionSum {
+	sum := sectionSum{}
+	shares := make(map[string]*holderShare)
+
+	for _, record := range section[1:] {
+		if record.Time == "N/A" {
+			continue
+		}
+		if record.Holder == "" {
+			sum.Unclaimed++
+			continue
+		}
+
+		var value float64
+		var err error
+		if record.IsTime {
+			value, err = parseTimeValue(record.Time)
+		} else {
+			var score int
+			score, err = parseScoreValue(record.Time)
+			value = float64(score)
+		}
+		if err != nil {
+			sum.Unclaimed++
+			continue
+		}
+
+		sum.Total += value
+		sum.Counted++
+		share, ok := shares[record.Holder]
+		if !ok {
+			share = &holderShare{Holder: record.Holder}
+			shares[record.Holder] = share
+		}
+		share.Stages++
+		share.Total += value
+	}
+
+	// Biggest contributors first
+	for _, share := range shares {
+		sum.Holders = append(sum.Holders, *share)
+	}
+	sort.Slice(sum.Holders, func(i, j int) bool {
+		if sum.Holders[i].Stages != sum.Holders[j].Stages {
+			return sum.Holders[i].Stages > sum.Holders[j].Stages
+		}
+		return sum.Holders[i].Holder < sum.Holders[j].Holder
+	})
+	return sum
+}
+
+// buildSobReply adds up every record of a difficulty or story world
+func buildSobReply(message string, games []string) string {
+	fields := strings.Fields(message)
+	if len(fields) < 3 {
+		return sobUsage
+	}
+	game := gameFromName(fields[1])
+	if game == "" || !hasGame(games, game) {
+		return sobUsage
+	}
+
+	// Times unless scores are asked for
+	args := fields[2:]
+	scoreType := "Time"
+	if strings.ToLower(args[len(args)-1]) == "score" {
+		scoreType = "Score"
+		args = args[:len(args)-1]
+	}
+	category, label := parseCategory(args)
+	if category == "" {
+		return sobUsage
+	}
+
+	section, ok := records[game+category+scoreType]
+	if !ok {
+		return "There are no " + gameDisplayName(game) + " " + label + " records"
+	}
+	sum := sumSection(section)
+
+	format := func(total float64) string {
+		if scoreType == "Time" {
+			return formatSeconds(total)
+		}
+		return strconv.Itoa(int(total))
+	}
+
+	returnMessage := gameDisplayName(game) + " " + label + " "
+	if scoreType == "Time" {
+		returnMessage += "sum of best: "
+	} else {
+		returnMessage += "score total: "
+	}
+	returnMessage += format(sum.Total) + " (" + strconv.Itoa(sum.Counted) + " stages)\n"
+
+	if sum.Unclaimed > 0 {
+		returnMessage += strconv.Itoa(sum.Unclaimed) + " unclaimed stages not counted"
+		if scoreType == "Time" {
+			returnMessage += " (" + formatSeconds(sum.Total+unclaimedTime*float64(sum.Unclaimed)) + " counting them as " + strconv.FormatFloat(unclaimedTime, 'f', 2, 64) + ")"
+		}
+		returnMessage += "\n"
+	}
+
+	if len(sum.Holders) > 0 {
+		contributions := make([]string, 0, len(sum.Holders))
+		for _, share := range sum.Holders {
+			contributions = append(contributions, fmt.Sprintf("%s %d (%s)", share.Holder, share.Stages, format(share.Total)))
+		}
+		returnMessage += "Holders: " + strings.Join(contributions, ", ") + "\n"
+	}
+	return returnMessage
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Time to wait between speedrun.com requests, to stay under the API's limit
+// of 100 requests a minute
+var speedrunDelay = 700 * time.Millisecond
+
+// Client for speedrun.com requests, so one that hangs doesn't hang the import
+var speedrunClient = &http.Client{Timeout: 30 * time.Second}
+
+// User agent sent with speedrun.com requests, as the API asks for
+const speedrunUserAgent = "SMB_Score_Bot (https://github.com/bobjrsenior/SMB_Score_Bot)"
+
+// speedrunLevel is a level of a game on speedrun.com
+type speedrunLevel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// speedrunCategory is a category of a game on speedrun.com. IL categories
+// have the type "per-level".
+type speedrunCategory struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// speedrunPlayer is a player of a run, either a user (only the id is given
+// unless the players are embedded) or a guest (only the name is given)
+type speedrunPlayer struct {
+	Rel   string `json:"rel,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Names *struct {
+		International string `json:"international"`
+	} `json:"names,omitempty"`
+}
+
+// speedrunLink is a link to a video of a run
+type speedrunLink struct {
+	URI string `json:"uri"`
+}
+
+// speedrunRun is a run on a speedrun.com leaderboard
+type speedrunRun struct {
+	Date  string `json:"date"`
+	Times struct {
+		PrimaryT float64 `json:"primary_t"`
+	} `json:"times"`
+	Videos *struct {
+		Links []speedrunLink `json:"links"`
+	} `json:"videos"`
+	Players []speedrunPlayer `json:"players"`
+}
+
+// speedrunPlace is a run along with its place on the leaderboard
+type speedrunPlace struct {
+	Place int         `json:"place"`
+	Run   speedrunRun `json:"run"`
+}
+
+// speedrunLeaderboard is the leaderboard of one level in one category, with
+// the players embedded
+type speedrunLeaderboard struct {
+	Level    string          `json:"level"`
+	Category string          `json:"category"`
+	Runs     []speedrunPlace `json:"runs"`
+	Players  struct {
+		Data []speedrunPlayer `json:"data"`
+	} `json:"players"`
+}
+
+// speedrunGame is everything imported for one game. A list of these is what
+// an export file holds.
+type speedrunGame struct {
+	Abbreviation string                `json:"abbreviation"`
+	Levels       []speedrunLevel       `json:"levels"`
+	Categories   []speedrunCategory    `json:"categories"`
+	Leaderboards []speedrunLeaderboard `json:"leaderboards"`
+}
+
+// speedrunImport is speedrun.com's data mapped onto the bot's stages
+type speedrunImport struct {
+	// Each stage's world record, in the same shape as the records from the sheet
+	Records map[string][]Record
+	// Every run on each stage's leaderboard, keyed by rankingKey
+	Rankings map[string][]rankedRun
+	// Levels that couldn't be matched to a stage, and why
+	Unmapped []string
+	// Sections of the imported games nothing is imported into, since only the
+	// times of the main stages are (ex: "Banana Mania scores" or "Banana Mania
+	// Reverse")
+	Skipped []string
+}
+
+// speedrunGet requests a path from the speedrun.com API and decodes the data
+// in the response into v
+func speedrunGet(path string, v interface{}) error {
+	request, err := http.NewRequest("GET", strings.TrimSuffix(*speedrunURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", speedrunUserAgent)
+	response, err := speedrunClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("speedrun.com returned %s for %s", response.Status, path)
+	}
+	body := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	return json.NewDecoder(response.Body).Decode(&body)
+}
+
+// fetchSpeedrunGame downloads a game's levels, categories and the leaderboard
+// of every level in the named IL category (the first one if it is empty)
+func fetchSpeedrunGame(abbreviation string, categoryName string) (speedrunGame, error) {
+	game := speedrunGame{Abbreviation: abbreviation}
+	err := speedrunGet("/games/"+abbreviation+"/levels", &game.Levels)
+	if err != nil {
+		return game, err
+	}
+	err = speedrunGet("/games/"+abbreviation+"/categories", &game.Categories)
+	if err != nil {
+		return game, err
+	}
+
+	// Only the category being imported is downloaded
+	categoryID := speedrunCategoryID(game, categoryName)
+	if categoryID == "" {
+		return game, nil
+	}
+	for _, level := range game.Levels {
+		time.Sleep(speedrunDelay)
+		var leaderboard speedrunLeaderboard
+		err = speedrunGet("/leaderboards/"+abbreviation+"/level/"+level.ID+"/"+categoryID+"?embed=players", &leaderboard)
+		if err != nil {
+			return game, err
+		}
+		leaderboard.Level = level.ID
+		leaderboard.Category = categoryID
+		game.Leaderboards = append(game.Leaderboards, leaderboard)
+	}
+	return game, nil
+}
+
+// speedrunGameKey returns the key of the game with the given speedrun.com
+// abbreviation, or "" if no game has it
+func speedrunGameKey(abbreviation string) string {
+	for _, game := range gameRegistry {
+		if game.Speedrun != "" && strings.EqualFold(game.Speedrun, abbreviation) {
+			return game.Key
+		}
+	}
+	return ""
+}
+
+// speedrunCategoryID returns the id of the IL category to import, the first
+// one if name is empty
+func speedrunCategoryID(game speedrunGame, name string) string {
+	for _, category := range game.Categories {
+		if category.Type != "per-level" {
+			continue
+		}
+		if name == "" || categorySlug(category.Name) == categorySlug(name) {
+			return category.ID
+		}
+	}
+	return ""
+}
+
+// mapSpeedrunLevel finds the stage a speedrun.com level is, by its name
+// matching a stage's name in the sheet (ex: "Simple" or "Zig-Zag")
+// or a stage code (ex: "b10" or "s3-7")
+func mapSpeedrunLevel(game string, level speedrunLevel, index map[string][]stageRef) (stageRef, string) {
+	if category, number, ok := parseStageCode(strings.Replace(level.Name, " ", "", -1)); ok {
+		section := records[game+category+"Time"]
+		if number < 1 || number >= len(section) {
+			return stageRef{}, "there is no stage " + strings.ToLower(level.Name)
+		}
+		return stageRef{Game: game, Category: category, Level: number}, ""
+	}
+	stages := index[stageIdentity(level.Name)]
+	if len(stages) == 0 {
+		return stageRef{}, "no stage has this name"
+	}
+	if len(stages) > 1 {
+		var codes []string
+		for _, stage := range stages {
+			codes = append(codes, stageCode(stage.Game, stage.Category, stage.Level))
+		}
+		return stageRef{}, "could be " + strings.Join(codes, ", ")
+	}
+	return stages[0], ""
+}
+
+// speedrunPlayerName returns the name of one of a run's players, looking users
+// up in the players embedded in the leaderboard
+func speedrunPlayerName(player speedrunPlayer, embedded []speedrunPlayer) string {
+	if player.Rel == "guest" || player.ID == "" {
+		return player.Name
+	}
+	for _, user := range embedded {
+		if user.ID == player.ID && user.Names != nil {
+			return user.Names.International
+		}
+	}
+	return player.ID
+}
+
+// importSpeedrun maps speedrun.com's leaderboards onto the bot's stages. Only
+// the given games and the named IL category (the first one if it is empty)
+// are imported. The records from the sheet are used to find the stages.
+func importSpeedrun(exports []speedrunGame, games []string, categoryName string) speedrunImport {
+	imported := speedrunImport{Records: make(map[string][]Record), Rankings: make(map[string][]rankedRun)}
+	for _, export := range exports {
+		game := speedrunGameKey(export.Abbreviation)
+		if game == "" || !hasGame(games, game) {
+			continue
+		}
+		categoryID := speedrunCategoryID(export, categoryName)
+		if categoryID == "" {
+			imported.Unmapped = append(imported.Unmapped, gameDisplayName(game)+": no IL category "+categoryName)
+			continue
+		}
+
+		// Find each level's stage. Only the main stages are matched, as a
+		// level's name is the same in every variant.
+		index := make(map[string][]stageRef)
+		for identity, refs := range stageIndex([]string{game}) {
+			for _, stage := range refs {
+				if _, variant := splitVariant(game, stage.Category); variant == nil {
+					index[identity] = append(index[identity], stage)
+				}
+			}
+		}
+		imported.Skipped = append(imported.Skipped, skippedSections(game)...)
+		stages := make(map[string]stageRef)
+		for _, level := range export.Levels {
+			stage, problem := mapSpeedrunLevel(game, level, index)
+			if problem != "" {
+				imported.Unmapped = append(imported.Unmapped, gameDisplayName(game)+" "+level.Name+": "+problem)
+				continue
+			}
+			stages[level.ID] = stage
+		}
+
+		for _, leaderboard := range export.Leaderboards {
+			stage, ok := stages[leaderboard.Level]
+			if !ok || leaderboard.Category != categoryID || len(leaderboard.Runs) == 0 {
+				continue
+			}
+
+			// Start from the sheet's stages so the levels line up, with every record open
+			mapKey := stage.Game + stage.Category + "Time"
+			if imported.Records[mapKey] == nil {
+				for _, record := range records[mapKey] {
+					if record.Holder != "" {
+						record.Holder, record.Time, record.Video = "", "", ""
+					}
+					imported.Records[mapKey] = append(imported.Records[mapKey], record)
+				}
+			}
+
+			places := leaderboard.Runs
+			sort.SliceStable(places, func(i, j int) bool {
+				return places[i].Place < places[j].Place
+			})
+			key := rankingKey(stage.Game, stage.Category, true, stage.Level)
+			for _, place := range places {
+				var names []string
+				for _, player := range place.Run.Players {
+					names = append(names, speedrunPlayerName(player, leaderboard.Players.Data))
+				}
+				run := rankedRun{Rank: place.Place, Holder: strings.Join(names, " & "), Value: formatSeconds(place.Run.Times.PrimaryT), Date: place.Run.Date}
+				if place.Run.Videos != nil && len(place.Run.Videos.Links) > 0 {
+					run.Video = place.Run.Videos.Links[0].URI
+				}
+				imported.Rankings[key] = append(imported.Rankings[key], run)
+			}
+
+			// The first run in first place is the world record
+			if len(places) > 0 && places[0].Place == 1 {
+				wr := imported.Rankings[key][0]
+				record := &imported.Records[mapKey][stage.Level]
+				record.Holder, record.Time, record.Video = wr.Holder, wr.Value, wr.Video
+			}
+		}
+	}
+	return imported
+}
+
+// skippedSections describes the sections of a game's records the import
+// doesn't fill: its scores and each of its variants
+func skippedSections(game string) []string {
+	scores := false
+	variants := make(map[string]bool)
+	for _, sec := range recordSections() {
+		if _, ok := records[sec.Key]; !ok || sec.Game != game {
+			continue
+		}
+		if sec.Variant != "" {
+			variants[sec.Variant] = true
+		} else if !sec.IsTime {
+			scores = true
+		}
+	}
+	var lines []string
+	if scores {
+		lines = append(lines, gameDisplayName(game)+" scores")
+	}
+	for _, v := range findGame(game).Variants {
+		if variants[v.Key] {
+			lines = append(lines, gameDisplayName(game)+" "+v.Name)
+		}
+	}
+	return lines
+}
+
+// speedrunDiscrepancies compares each imported world record with the record
+// in the sheet, describing every stage where they differ
+func speedrunDiscrepancies(imported speedrunImport) []string {
+	var lines []string
+	for _, sec := range recordSections() {
+		srcom, ok := imported.Records[sec.Key]
+		if !ok {
+			continue
+		}
+		sheet := records[sec.Key]
+		for level := 1; level < len(srcom) && level < len(sheet); level++ {
+			theirs, ours := srcom[level], sheet[level]
+			if theirs.Holder == "" || ours.Time == "N/A" {
+				continue
+			}
+			name := gameDisplayName(sec.Game) + " " + stageCode(sec.Game, sec.Category(), level) + " (" + ours.Name + "): "
+			if ours.Holder == "" {
+				lines = append(lines, name+"open in the sheet, "+theirs.Time+" by "+theirs.Holder+" on speedrun.com")
+				continue
+			}
+			sheetTime, err := parseTimeValue(ours.Time)
+			if err != nil {
+				continue
+			}
+			srcomTime, _ := parseTimeValue(theirs.Time)
+			difference := ""
+			if srcomTime < sheetTime {
+				difference = " (" + formatSeconds(sheetTime-srcomTime) + " faster on speedrun.com)"
+			} else if srcomTime > sheetTime {
+				difference = " (" + formatSeconds(srcomTime-sheetTime) + " faster in the sheet)"
+			} else if strings.EqualFold(theirs.Holder, ours.Holder) {
+				continue
+			}
+			lines = append(lines, name+"sheet "+ours.Time+" by "+ours.Holder+", speedrun.com "+theirs.Time+" by "+theirs.Holder+difference)
+		}
+	}
+	return lines
+}
+
+// speedrunRankings turns the imported runs into a list that can be loaded
+// with -rankings-file
+func speedrunRankings(imported speedrunImport) []rankedStage {
+	var list []rankedStage
+	for _, sec := range recordSections() {
+		for level := 1; level < len(imported.Records[sec.Key]); level++ {
+			runs := imported.Rankings[rankingKey(sec.Game, sec.Category(), sec.IsTime, level)]
+			if len(runs) > 0 {
+				list = append(list, rankedStage{Game: sec.Game, Stage: stageCode(sec.Game, sec.Category(), level), Type: scoreTypeName(sec.IsTime), Runs: runs})
+			}
+		}
+	}
+	return list
+}
+
+// runSpeedrun imports speedrun.com's IL leaderboards from the terminal and
+// reports where they differ from the sheet
+//
+//	scorebot srcom
+//	scorebot srcom -game smb2 -category normal -rankings srcom-rankings.json
+//	scorebot srcom -file export.json -offline -save srcom-records.json
+func runSpeedrun(args []string) {
+	speedrunFlags := flag.NewFlagSet("srcom", flag.ExitOnError)
+	game := speedrunFlags.String("game", "", "Only import this game ("+gameNameList()+")")
+	file := speedrunFlags.String("file", "", "Read a speedrun.com export instead of using the API")
+	category := speedrunFlags.String("category", "", "IL category to import (default: the game's first one)")
+	offline := speedrunFlags.Bool("offline", false, "Use the cached records instead of fetching the sheet")
+	dump := speedrunFlags.String("dump", "", "Write what was downloaded to this file, to be read back with -file")
+	save := speedrunFlags.String("save", "", "Write the imported world records to this file, in the same format as the cache")
+	rankings := speedrunFlags.String("rankings", "", "Write every imported run to this file, to be used with -rankings-file")
+	speedrunFlags.Parse(args)
+
+	games := commandGames(*game)
+	loadCommandRecords(*offline)
+
+	// Read the leaderboards from the export or the API
+	var exports []speedrunGame
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
+		if err == nil {
+			err = json.Unmarshal(data, &exports)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error reading the speedrun.com export,", err)
+			os.Exit(1)
+		}
+	} else {
+		for _, key := range games {
+			info := findGame(key)
+			if info.Speedrun == "" {
+				continue
+			}
+			export, err := fetchSpeedrunGame(info.Speedrun, *category)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error downloading from speedrun.com,", err)
+				os.Exit(1)
+			}
+			exports = append(exports, export)
+		}
+	}
+	if *dump != "" {
+		if err := writeJSONFile(*dump, exports); err != nil {
+			fmt.Fprintln(os.Stderr, "error writing the speedrun.com export,", err)
+			os.Exit(1)
+		}
+	}
+
+	imported := importSpeedrun(exports, games, *category)
+	if *save != "" {
+		if err := writeJSONFile(*save, imported.Records); err != nil {
+			fmt.Fprintln(os.Stderr, "error writing the imported records,", err)
+			os.Exit(1)
+		}
+	}
+	if *rankings != "" {
+		if err := writeJSONFile(*rankings, speedrunRankings(imported)); err != nil {
+			fmt.Fprintln(os.Stderr, "error writing the imported runs,", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Print(formatSpeedrunReport(imported))
+}
+
+// formatSpeedrunReport lists the levels that couldn't be imported, the
+// sections that weren't compared and the stages where speedrun.com and the
+// sheet disagree
+func formatSpeedrunReport(imported speedrunImport) string {
+	report := ""
+	if len(imported.Unmapped) > 0 {
+		report += "Not imported:\n"
+		for _, line := range imported.Unmapped {
+			report += "  " + line + "\n"
+		}
+	}
+	if len(imported.Skipped) > 0 {
+		report += "Not compared, only the times of the main stages are imported:\n"
+		for _, line := range imported.Skipped {
+			report += "  " + line + "\n"
+		}
+	}
+	discrepancies := speedrunDiscrepancies(imported)
+	if len(discrepancies) > 0 {
+		report += "Records that differ from the sheet:\n"
+		for _, line := range discrepancies {
+			report += "  " + line + "\n"
+		}
+	}
+	if report == "" {
+		report = "speedrun.com agrees with the sheet\n"
+	}
+	return report
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadSpeedrunExport reads the speedrun.com export fixture from testdata
+func loadSpeedrunExport(t *testing.T) []speedrunGame {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "speedrun.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exports []speedrunGame
+	if err := json.Unmarshal(data, &exports); err != nil {
+		t.Fatal(err)
+	}
+	return exports
+}
+
+// newFakeSpeedrun starts a fake speedrun.com API serving the games in exports
+// and points speedrunURL at it
+func newFakeSpeedrun(t *testing.T, exports []speedrunGame) {
+	serve := func(w http.ResponseWriter, data interface{}) {
+		writeTestJSON(w, map[string]interface{}{"data": data})
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != speedrunUserAgent {
+			http.Error(w, "no user agent", http.StatusBadRequest)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/"), "/")
+		for _, export := range exports {
+			switch {
+			case len(parts) == 3 && parts[0] == "games" && parts[1] == export.Abbreviation && parts[2] == "levels":
+				serve(w, export.Levels)
+				return
+			case len(parts) == 3 && parts[0] == "games" && parts[1] == export.Abbreviation && parts[2] == "categories":
+				serve(w, export.Categories)
+				return
+			case len(parts) == 5 && parts[0] == "leaderboards" && parts[1] == export.Abbreviation && parts[2] == "level":
+				if r.URL.Query().Get("embed") != "players" {
+					http.Error(w, "players aren't embedded", http.StatusBadRequest)
+					return
+				}
+				for _, leaderboard := range export.Leaderboards {
+					if leaderboard.Level == parts[3] && leaderboard.Category == parts[4] {
+						serve(w, leaderboard)
+						return
+					}
+				}
+				serve(w, speedrunLeaderboard{Level: parts[3], Category: parts[4], Runs: []speedrunPlace{}})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(server.Close)
+	setFlag(t, speedrunURL, server.URL+"/api/v1/")
+
+	oldDelay := speedrunDelay
+	speedrunDelay = 0
+	t.Cleanup(func() { speedrunDelay = oldDelay })
+}
+
+func TestFetchSpeedrunGame(t *testing.T) {
+	loadFixtureRecords(t)
+	exports := loadSpeedrunExport(t)
+	newFakeSpeedrun(t, exports)
+
+	for _, category := range []string{"", "warpless"} {
+		fetched, err := fetchSpeedrunGame("smb2", category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the imported category is fetched, one leaderboard per level,
+		// and it imports the same way as the export
+		if want := len(exports[0].Levels); len(fetched.Leaderboards) != want {
+			t.Errorf("category %q: fetched %d leaderboards, want %d", category, len(fetched.Leaderboards), want)
+		}
+		got := importSpeedrun([]speedrunGame{fetched}, allGames, category)
+		want := importSpeedrun(exports, allGames, category)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("category %q: importing from the API gave\n%+v\nwant\n%+v", category, got, want)
+		}
+	}
+
+	// A category the game doesn't have isn't fetched at all
+	fetched, err := fetchSpeedrunGame("smb2", "glitched")
+	if err != nil || len(fetched.Leaderboards) != 0 {
+		t.Errorf("fetching a missing category gave %d leaderboards and %v, want none", len(fetched.Leaderboards), err)
+	}
+
+	if _, err := fetchSpeedrunGame("smb9", ""); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("fetching an unknown game gave %v, want a 404", err)
+	}
+}
+
+func TestSpeedrunRequestTimesOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	setFlag(t, speedrunURL, server.URL)
+	oldClient := speedrunClient
+	speedrunClient = &http.Client{Timeout: 20 * time.Millisecond}
+	defer func() { speedrunClient = oldClient }()
+
+	var levels []speedrunLevel
+	if err := speedrunGet("/games/smb2/levels", &levels); err == nil {
+		t.Error("a request that hangs didn't time out")
+	}
+}
+
+func TestSpeedrunRequestsAreSpacedOut(t *testing.T) {
+	loadFixtureRecords(t)
+	newFakeSpeedrun(t, loadSpeedrunExport(t)[1:])
+	speedrunDelay = 5 * time.Millisecond
+
+	start := time.Now()
+	if _, err := fetchSpeedrunGame("smb3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < speedrunDelay {
+		t.Errorf("fetching took %v, want at least %v between requests", elapsed, speedrunDelay)
+	}
+}
+
+func TestMapSpeedrunLevel(t *testing.T) {
+	loadFixtureRecords(t)
+	index := stageIndex([]string{"SMB2"})
+
+	tests := []struct {
+		name    string
+		stage   string
+		problem string
+	}{
+		// Simple is also story 1-1, but the challenge stage is the one meant
+		{"Simple", "b1", ""},
+		{"Zig-Zag", "b8", ""},
+		{"bowl", "s1-10", ""},
+		{"Expert 10", "e10", ""},
+		{"m99", "", "there is no stage m99"},
+		{"Bonus Basic", "", "no stage has this name"},
+	}
+	for _, test := range tests {
+		stage, problem := mapSpeedrunLevel("SMB2", speedrunLevel{Name: test.name}, index)
+		got := ""
+		if problem == "" {
+			got = stageCode(stage.Game, stage.Category, stage.Level)
+		}
+		if got != test.stage || problem != test.problem {
+			t.Errorf("%s: got %q %q, want %q %q", test.name, got, problem, test.stage, test.problem)
+		}
+	}
+
+	// A name more than one stage has isn't guessed
+	index["simple"] = append(index["simple"], stageRef{Game: "SMB2", Category: "Expert", Level: 3})
+	if _, problem := mapSpeedrunLevel("SMB2", speedrunLevel{Name: "Simple"}, index); problem != "could be b1, e3" {
+		t.Errorf("an ambiguous name gave %q, want could be b1, e3", problem)
+	}
+}
+
+func TestImportSpeedrun(t *testing.T) {
+	loadFixtureRecords(t)
+	exports := loadSpeedrunExport(t)
+
+	imported := importSpeedrun(exports, allGames, "")
+	if want := []string{"SMB2 Bonus Basic: no stage has this name"}; !reflect.DeepEqual(imported.Unmapped, want) {
+		t.Errorf("unmapped levels %q, want %q", imported.Unmapped, want)
+	}
+	// The world record is the first run in first place, with players looked
+	// up by id and guests by name
+	tests := []struct {
+		key    string
+		level  int
+		holder string
+		time   string
+	}{
+		{"SMB2BeginnerTime", 1, "Ghost Ship", "28.80"},
+		{"SMB2BeginnerTime", 4, "cyclopsdragon & Alex", "32.04"},
+		{"SMB2BeginnerTime", 7, "", ""},
+		{"SMB2Story1Time", 10, "Alex", "1:01.50"},
+	}
+	for _, test := range tests {
+		record := imported.Records[test.key][test.level]
+		if record.Holder != test.holder || record.Time != test.time {
+			t.Errorf("%s %d: got %s by %q, want %s by %q", test.key, test.level, record.Time, record.Holder, test.time, test.holder)
+		}
+	}
+	// Tied runs keep speedrun.com's places
+	runs := imported.Rankings[rankingKey("SMB2", "Beginner", true, 3)]
+	if got := rankList(runs); got != "1 Ghost Ship 54.00, 1 Alex 54.00" {
+		t.Errorf("tied runs: got %q", got)
+	}
+
+	// Another category, one the game doesn't have and a game with no levels imported
+	if got := importSpeedrun(exports, allGames, "Warpless").Records["SMB2BeginnerTime"][1].Holder; got != "Warpless" {
+		t.Errorf("Warpless b1 holder %q, want Warpless", got)
+	}
+	if got := importSpeedrun(exports, allGames, "Glitched").Unmapped; !reflect.DeepEqual(got, []string{"SMB2: no IL category Glitched"}) {
+		t.Errorf("a missing category gave %q", got)
+	}
+	if got := importSpeedrun(exports, []string{"SMB1"}, ""); len(got.Records) != 0 || len(got.Unmapped) != 0 {
+		t.Errorf("importing SMB1 only gave %+v, want nothing", got)
+	}
+}
+
+func TestImportSpeedrunVariants(t *testing.T) {
+	loadFixtureRecords(t)
+	exports := []speedrunGame{{
+		Abbreviation: "smbbm",
+		Levels:       []speedrunLevel{{ID: "l1", Name: "Beginner 1"}},
+		Categories:   []speedrunCategory{{ID: "c1", Name: "Normal", Type: "per-level"}},
+		Leaderboards: []speedrunLeaderboard{{Level: "l1", Category: "c1", Runs: []speedrunPlace{{Place: 1, Run: speedrunRun{Players: []speedrunPlayer{{Rel: "guest", Name: "Nambo"}}}}}}},
+	}}
+
+	// The level is the main stage, not the same stage in a variant
+	imported := importSpeedrun(exports, allGames, "")
+	if len(imported.Unmapped) != 0 || imported.Records["BMBeginnerTime"][1].Holder != "Nambo" {
+		t.Errorf("Beginner 1 wasn't imported as BM b1: %q", imported.Unmapped)
+	}
+	if want := []string{"Banana Mania scores", "Banana Mania Reverse"}; !reflect.DeepEqual(imported.Skipped, want) {
+		t.Errorf("skipped sections %q, want %q", imported.Skipped, want)
+	}
+	if report := formatSpeedrunReport(imported); !strings.Contains(report, "Not compared, only the times of the main stages are imported:\n  Banana Mania scores\n  Banana Mania Reverse\n") {
+		t.Errorf("the report doesn't list the skipped sections:\n%s", report)
+	}
+}
+
+func TestSpeedrunDiscrepancies(t *testing.T) {
+	loadFixtureRecords(t)
+	lines := speedrunDiscrepancies(importSpeedrun(loadSpeedrunExport(t), allGames, ""))
+
+	want := []string{
+		"SMB2 b2 (Hollow): sheet 54.28 by CyclopsDragon, speedrun.com 53.90 by Nambo (0.38 faster on speedrun.com)",
+		"SMB2 b3 (Bumpy): sheet 53.24 by Ghost Ship, speedrun.com 54.00 by Ghost Ship (0.76 faster in the sheet)",
+		// Same time, different holder
+		"SMB2 b4 (Switches): sheet 32.04 by CyclopsDragon, speedrun.com 32.04 by cyclopsdragon & Alex",
+		"SMB2 b8 (Zig Zag): sheet 21.00 by Ghost Ship, speedrun.com 31.64 by Bobjrsenior (10.64 faster in the sheet)",
+		"SMB2 s1-10 (Bowl): sheet 26.88 by CyclopsDragon, speedrun.com 1:01.50 by Alex (34.62 faster in the sheet)",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if got := formatSpeedrunReport(importSpeedrun(loadSpeedrunExport(t), []string{"SMB1"}, "")); got != "speedrun.com agrees with the sheet\n" {
+		t.Errorf("an import that agrees gave %q", got)
+	}
+}
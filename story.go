@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Reply for a story query that can't be understood
+const storyUsage = "Usage: !s<world>-<floor>, !s <world> <floor>, !w<world>f<floor> or !story <world> <floor> (ex: !s3-7)"
+
+// Messages that are meant to be story queries, even if they are malformed
+var storyQueryStart = regexp.MustCompile(`^!(s|story)([\s\d-]|$)|^!w\d`)
+
+// Every accepted way of asking for a story stage. The first group is the
+// world and the second the floor.
+var storyQueryPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^!s\s*(\d+)\s*-\s*(\d+)$`),
+	regexp.MustCompile(`^!s\s+(\d+)\s+(\d+)$`),
+	regexp.MustCompile(`^!w(\d+)f(\d+)$`),
+	regexp.MustCompile(`^!story\s+(\d+)(?:\s+|\s*-\s*)(\d+)$`),
+}
+
+// isStoryQuery reports whether the message is asking for a story stage
+func isStoryQuery(message string) bool {
+	return storyQueryStart.MatchString(message)
+}
+
+// parseStoryQuery returns the world and floor asked for in a story query
+func parseStoryQuery(message string) (world int, floor int, ok bool) {
+	for _, pattern := range storyQueryPatterns {
+		match := pattern.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		world, err := strconv.Atoi(match[1])
+		if err != nil {
+			return 0, 0, false
+		}
+		floor, err := strconv.Atoi(match[2])
+		if err != nil {
+			return 0, 0, false
+		}
+		return world, floor, true
+	}
+	return 0, 0, false
+}
+
+// storyWorldCount returns the highest story world loaded for any of the games
+func storyWorldCount(games []string) int {
+	count := 0
+	for _, game := range games {
+		for world := count + 1; storyFloorCount(game, world) > 0; world++ {
+			count = world
+		}
+	}
+	return count
+}
+
+// storyFloorCount returns how many floors a story world has in a game, or 0
+// if the world isn't loaded
+func storyFloorCount(game string, world int) int {
+	section, ok := records[game+"Story"+strconv.Itoa(world)+"Time"]
+	if !ok {
+		return 0
+	}
+	return section[0].Index - 1
+}
+
+// buildStoryReply answers a story query, or explains what is wrong with it
+func buildStoryReply(message string, games []string) string {
+	world, level, ok := parseStoryQuery(message)
+	if !ok {
+		return storyUsage
+	}
+
+	// Make sure the stage exists in at least one game
+	worldCount := storyWorldCount(games)
+	if worldCount == 0 {
+		return "No story records are loaded right now"
+	}
+	if world < 1 || world > worldCount {
+		return "There is no world " + strconv.Itoa(world) + ", story worlds go from 1 to " + strconv.Itoa(worldCount)
+	}
+	floorCount := 0
+	for _, game := range games {
+		if floors := storyFloorCount(game, world); floors > floorCount {
+			floorCount = floors
+		}
+	}
+	if level < 1 || level > floorCount {
+		return "World " + strconv.Itoa(world) + " only has floors 1 to " + strconv.Itoa(floorCount)
+	}
+
+	difficulty := "Story"
+	worldString := strconv.Itoa(world)
+
+	// Start building the return message
+	returnMessage := ""
+
+	for _, game := range games {
+		// Get the formatted record holders for the game
+		storyTime := retrieveRecordStoryString(game, difficulty, "Time", worldString, level)
+		storyScore := retrieveRecordStoryString(game, difficulty, "Score", worldString, level)
+		// If a record exists
+		if storyTime != "" {
+			// Add the game's information to the return message
+			storyName := gameDisplayName(game) + " " + getStoryLevelName(game, difficulty, "Time", worldString, level)
+			if storyTime == "Duplicate Stage" {
+				returnMessage += storyName + ": " + storyTime + "\n"
+			} else {
+				returnMessage += storyName + ": " + storyTime + ", " + storyScore + "\n"
+			}
+		}
+	}
+	return returnMessage
+}
+
+// Reply for a world summary that can't be understood
+const worldUsage = "Usage: !world <smb2|smbdx> <world> (ex: !world smb2 3)"
+
+// buildWorldReply lists every floor of a story world with its records, along
+// with the world's total time and score
+func buildWorldReply(message string, games []string) string {
+	fields := strings.Fields(message)
+	if len(fields) != 3 {
+		return worldUsage
+	}
+	game := gameFromName(fields[1])
+	world, err := strconv.Atoi(fields[2])
+	if game == "" || err != nil || !hasGame(games, game) {
+		return worldUsage
+	}
+
+	worldString := strconv.Itoa(world)
+	timeRecords, ok := records[game+"Story"+worldString+"Time"]
+	if !ok {
+		worldCount := storyWorldCount([]string{game})
+		if worldCount == 0 {
+			return "There are no story records for " + gameDisplayName(game)
+		}
+		return "There is no world " + worldString + ", story worlds go from 1 to " + strconv.Itoa(worldCount)
+	}
+	scoreRecords := records[game+"Story"+worldString+"Score"]
+
+	// Work out how wide the name and holder columns need to be
+	nameWidth, holderWidth := len("Stage"), len("Holder")
+	for _, record := range timeRecords[1:] {
+		nameWidth = maxInt(nameWidth, len(record.Name))
+		holderWidth = maxInt(holderWidth, len(record.Holder))
+		if record.Time == "N/A" {
+			holderWidth = maxInt(holderWidth, len("Duplicate Stage"))
+		}
+	}
+	for floor := 1; floor < len(scoreRecords); floor++ {
+		holderWidth = maxInt(holderWidth, len(scoreRecords[floor].Holder))
+	}
+
+	row := "%2s  %-*s  %8s  %-*s  %7s  %s\n"
+	table := fmt.Sprintf(row, "#", nameWidth, "Stage", "Time", holderWidth, "Holder", "Score", "Holder")
+
+	totalTime := 0.0
+	totalScore := 0
+	unclaimed := 0
+	for floor := 1; floor < len(timeRecords); floor++ {
+		timeRecord := timeRecords[floor]
+		scoreRecord := Record{}
+		if floor < len(scoreRecords) {
+			scoreRecord = scoreRecords[floor]
+		}
+
+		// Duplicate stages don't count towards the world's totals
+		if timeRecord.Time == "N/A" {
+			table += fmt.Sprintf(row, strconv.Itoa(floor), nameWidth, timeRecord.Name, "N/A", holderWidth, "Duplicate Stage", "N/A", "")
+			continue
+		}
+
+		timeValue, timeHolder := "-", "-"
+		if seconds, err := parseTimeValue(timeRecord.Time); err == nil && timeRecord.Holder != "" {
+			totalTime += seconds
+			timeValue, timeHolder = timeRecord.Time, timeRecord.Holder
+		} else {
+			unclaimed++
+		}
+		scoreValue, scoreHolder := "-", "-"
+		if score, err := parseScoreValue(scoreRecord.Time); err == nil && scoreRecord.Holder != "" {
+			totalScore += score
+			scoreValue, scoreHolder = scoreRecord.Time, scoreRecord.Holder
+		} else {
+			unclaimed++
+		}
+		table += fmt.Sprintf(row, strconv.Itoa(floor), nameWidth, timeRecord.Name, timeValue, holderWidth, timeHolder, scoreValue, scoreHolder)
+	}
+
+	returnMessage := gameDisplayName(game) + " World " + worldString + "\n```\n" + table + "```\n"
+	returnMessage += "Total: " + formatSeconds(totalTime) + ", " + strconv.Itoa(totalScore)
+	if unclaimed > 0 {
+		returnMessage += " (" + strconv.Itoa(unclaimed) + " unclaimed records not counted)"
+	}
+	return returnMessage + "\n"
+}
+
+// formatSeconds formats a number of seconds the way times are written in the
+// sheet, adding minutes when there are any (ex: 7:23.45)
+func formatSeconds(seconds float64) string {
+	hundredths := int(seconds*100 + 0.5)
+	if hundredths < 6000 {
+		return fmt.Sprintf("%d.%02d", hundredths/100, hundredths%100)
+	}
+	return fmt.Sprintf("%d:%02d.%02d", hundredths/6000, hundredths/100%60, hundredths%100)
+}
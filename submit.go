@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	discordgo "github.com/bwmarrin/discordgo"
+)
+
+// Reply for a submission that can't be understood
+const submitUsage = "Usage: !submit [game] <stage> <time|score> <video> (ex: !submit smb2 b10 11.80 https://youtu.be/...)"
+
+// Reactions moderators use on a review message
+const (
+	approveEmoji = "✅"
+	rejectEmoji  = "❌"
+)
+
+// submission is a new record someone has asked to have added to the sheet
+type submission struct {
+	ID        int       `json:"id"`
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	ChannelID string    `json:"channelId"`
+	Game      string    `json:"game"`
+	Category  string    `json:"category"`
+	Level     int       `json:"level"`
+	IsTime    bool      `json:"isTime"`
+	Value     string    `json:"value"`
+	Video     string    `json:"video"`
+	Previous  string    `json:"previous"`
+	Submitted time.Time `json:"submitted"`
+
+	// The holder name written to the sheet, set when it is approved
+	Holder string `json:"holder,omitempty"`
+
+	// Filled in by the review
+	Status          string    `json:"status"`
+	ReviewMessageID string    `json:"reviewMessageId,omitempty"`
+	Moderator       string    `json:"moderator,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+	Decided         time.Time `json:"decided,omitempty"`
+}
+
+// Statuses a submission can have
+const (
+	statusPending  = "pending"
+	statusApproved = "approved"
+	statusRejected = "rejected"
+)
+
+// Every submission, saved to submissionsFile whenever one changes
+var submissions []submission
+var submissionsLock sync.Mutex
+
+// Stage returns the stage the submission is for
+func (sub submission) Stage() stageRef {
+	return stageRef{Game: sub.Game, Category: sub.Category, Level: sub.Level}
+}
+
+// String describes the submission on one line
+func (sub submission) String() string {
+	return "#" + strconv.Itoa(sub.ID) + " " + gameDisplayName(sub.Game) + " " + stageCode(sub.Game, sub.Category, sub.Level) + " " + getLevelName(sub.Game, sub.Category, "Time", sub.Level) + " " + scoreTypeName(sub.IsTime) + " " + sub.Value + " by " + sub.Username
+}
+
+// loadSubmissions reads the submissions from submissionsFile. A missing file
+// just means nothing has been submitted yet.
+func loadSubmissions() error {
+	data, err := ioutil.ReadFile(*submissionsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	submissionsLock.Lock()
+	defer submissionsLock.Unlock()
+	return json.Unmarshal(data, &submissions)
+}
+
+// submitRecord handles !submit: it checks the new record against the current
+// one, queues it and asks the moderators to review it
+func submitRecord(message string, author *discordgo.User, channelID string) string {
+	stage, rest, problem := parseStage(strings.Fields(message)[1:], allGames)
+	if problem != "" {
+		return problem + "\n" + submitUsage
+	}
+	if len(rest) != 2 {
+		return submitUsage
+	}
+
+	// Times have a decimal point, scores don't
+	value := rest[0]
+	isTime := strings.ContainsAny(value, ".:")
+	number, err := recordValue(isTime, value)
+	if err != nil {
+		return value + " isn't a time or a score\n" + submitUsage
+	}
+	video := strings.Trim(rest[1], "<>")
+	if problem := checkVideoLink(video); problem != "" {
+		return "That video link doesn't look right (" + problem + ")"
+	}
+
+	// It has to at least tie the current record
+	previous := "none"
+	section := records[stage.Game+stage.Category+scoreTypeKey(isTime)]
+	if stage.Level < len(section) && section[stage.Level].Holder != "" {
+		record := section[stage.Level]
+		recordNumber, err := recordValue(isTime, record.Time)
+		if err == nil && behindBy(isTime, number, recordNumber) > 0 {
+			return "That doesn't beat the record (" + gapDescription(stage, isTime, number) + ")"
+		}
+		previous = record.Time + " by " + record.Holder
+	}
+
+	sub := submission{UserID: author.ID, Username: author.Username, ChannelID: channelID, Game: stage.Game, Category: stage.Category, Level: stage.Level, IsTime: isTime, Value: value, Video: video, Previous: previous, Submitted: time.Now().UTC(), Status: statusPending}
+
+	submissionsLock.Lock()
+	sub.ID = len(submissions) + 1
+	submissions = append(submissions, sub)
+	err = writeJSONFile(*submissionsFile, submissions)
+	submissionsLock.Unlock()
+	if err != nil {
+		return "Your submission couldn't be saved: " + err.Error()
+	}
+
+	requestReview(sub)
+	return "Submitted " + sub.String() + " for review"
+}
+
+// requestReview posts a submission in the review channel with reactions for
+// the moderators to approve or reject it
+func requestReview(sub submission) {
+	if *reviewChannel == "" || discordSession == nil {
+		return
+	}
+	review := "New record submission " + sub.String() + "\n"
+	review += "Previous record: " + sub.Previous + "\n"
+	review += "Video: <" + sub.Video + ">\n"
+	review += "React " + approveEmoji + " to approve or " + rejectEmoji + " to reject (or use !approve " + strconv.Itoa(sub.ID) + " [holder] / !reject " + strconv.Itoa(sub.ID) + " <reason>)"
+
+	reviewMessage, err := discordSession.ChannelMessageSend(*reviewChannel, review)
+	if err != nil {
+		fmt.Println("error sending review request,", err)
+		return
+	}
+	_ = discordSession.MessageReactionAdd(*reviewChannel, reviewMessage.ID, approveEmoji)
+	_ = discordSession.MessageReactionAdd(*reviewChannel, reviewMessage.ID, rejectEmoji)
+
+	submissionsLock.Lock()
+	defer submissionsLock.Unlock()
+	submissions[sub.ID-1].ReviewMessageID = reviewMessage.ID
+	err = writeJSONFile(*submissionsFile, submissions)
+	if err != nil {
+		fmt.Println("error saving submissions,", err)
+	}
+}
+
+// isReviewCommand reports whether the message is !approve or !reject, and
+// not just a word starting with them (ex: "!approved")
+func isReviewCommand(message string) bool {
+	fields := strings.Fields(message)
+	return len(fields) > 0 && (fields[0] == "!approve" || fields[0] == "!reject")
+}
+
+// Reply for a review command that can't be understood
+const reviewUsage = "Usage: !approve <id> [holder] or !reject <id> [reason]"
+
+// reviewCommand handles "!approve <id> [holder]" and "!reject <id> [reason]".
+// The holder is the name written to the sheet.
+func reviewCommand(message string, moderator *discordgo.User) string {
+	fields := strings.Fields(message)
+	if len(fields) < 2 {
+		return reviewUsage
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(fields[1], "#"))
+	if err != nil {
+		return reviewUsage
+	}
+	rest := strings.Join(fields[2:], " ")
+	if fields[0] == "!approve" {
+		return decideSubmission(id, true, moderator.Username, "", rest)
+	}
+	return decideSubmission(id, false, moderator.Username, rest, "")
+}
+
+// messageReactionAdd lets moderators review submissions by reacting to them
+func messageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == discBotID || r.ChannelID != *reviewChannel {
+		return
+	}
+	if r.Emoji.Name != approveEmoji && r.Emoji.Name != rejectEmoji {
+		return
+	}
+	user, err := s.User(r.UserID)
+	if err != nil || !isAdmin(user) {
+		return
+	}
+
+	id := 0
+	submissionsLock.Lock()
+	for _, sub := range submissions {
+		if sub.ReviewMessageID == r.MessageID {
+			id = sub.ID
+		}
+	}
+	submissionsLock.Unlock()
+	if id == 0 {
+		return
+	}
+
+	recordsLock.RLock()
+	reply := decideSubmission(id, r.Emoji.Name == approveEmoji, user.Username, "", "")
+	recordsLock.RUnlock()
+	_, _ = s.ChannelMessageSend(r.ChannelID, reply)
+}
+
+// decideSubmission approves or rejects a pending submission, logs the
+// decision and lets the submitter know. It returns a reply for the moderator.
+// With write-back on, holder is the name written to the sheet; if it is empty
+// the submitter's name has to already be in the sheet. An approval is only
+// saved once the record is written, so a failed write can be approved again.
+func decideSubmission(id int, approve bool, moderator string, reason string, holder string) string {
+	submissionsLock.Lock()
+	defer submissionsLock.Unlock()
+	if id < 1 || id > len(submissions) {
+		return "There is no submission #" + strconv.Itoa(id)
+	}
+	sub := &submissions[id-1]
+	if sub.Status != statusPending {
+		return "Submission #" + strconv.Itoa(id) + " was already " + sub.Status + " by " + sub.Moderator
+	}
+	writing := approve && (*writeBack || *writeDryRun)
+	if writing && holder == "" {
+		holder = sheetHolderName(sub.Username)
+		if holder == "" {
+			return sub.Username + " doesn't hold any records in the sheet, so their name there isn't known. Use !approve " + strconv.Itoa(id) + " <holder> to approve it"
+		}
+	}
+
+	decided := *sub
+	decided.Status = statusRejected
+	if approve {
+		decided.Status = statusApproved
+	}
+	decided.Moderator = moderator
+	decided.Reason = reason
+	decided.Holder = holder
+	decided.Decided = time.Now().UTC()
+
+	// Put approved records in the sheet if write-back is on. The lock stays
+	// held so two moderators can't write the same submission.
+	written := ""
+	if writing {
+		var err error
+		written, err = writeRecord(decided)
+		if err != nil {
+			fmt.Println("error writing submission to the sheet,", err)
+			return "Submission #" + strconv.Itoa(id) + " couldn't be written to the sheet, so it is still pending: " + err.Error()
+		}
+	}
+
+	*sub = decided
+	err := writeJSONFile(*submissionsFile, submissions)
+	if err != nil {
+		fmt.Println("error saving submissions,", err)
+	}
+	err = logDecision(decided)
+	if err != nil {
+		fmt.Println("error logging submission decision,", err)
+	}
+
+	// Let the submitter know
+	notice := "<@" + decided.UserID + "> your submission " + decided.String() + " was " + decided.Status
+	if reason != "" {
+		notice += ": " + reason
+	}
+	if discordSession != nil && decided.ChannelID != "" {
+		_, _ = discordSession.ChannelMessageSend(decided.ChannelID, notice)
+	}
+	reply := "Submission " + decided.String() + " " + decided.Status
+	if written != "" {
+		reply += "\n" + written
+	}
+	return reply
+}
+
+// logDecision appends a decided submission to submissionLog as a line of JSON,
+// so approved records can be entered in the sheet later
+func logDecision(sub submission) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(*submissionLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	_, err = logFile.Write(append(data, '\n'))
+	return err
+}
+
+// listPendingSubmissions handles !pending
+func listPendingSubmissions() string {
+	submissionsLock.Lock()
+	defer submissionsLock.Unlock()
+
+	returnMessage := ""
+	for _, sub := range submissions {
+		if sub.Status == statusPending {
+			returnMessage += sub.String() + " <" + sub.Video + ">\n"
+		}
+	}
+	if returnMessage == "" {
+		return "No submissions are waiting for review"
+	}
+	return "Waiting for review:\n" + returnMessage
+}
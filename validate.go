@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// How many problems to list in a Discord message before cutting it short
+const maxAlertIssues = 15
+
+// sheetIssue is a problem found in the IL spreadsheet. Row and Column are zero
+// based and are -1 when the problem is not about a single cell.
+type sheetIssue struct {
+	Tab     string
+	Section string
+	Row     int
+	Column  int
+	Reason  string
+}
+
+func (issue sheetIssue) String() string {
+	where := "'" + issue.Tab + "'"
+	if issue.Row >= 0 && issue.Column >= 0 {
+		where += "!" + cellName(issue.Row, issue.Column)
+	} else if issue.Row >= 0 {
+		where += " row " + strconv.Itoa(issue.Row+1)
+	}
+	if issue.Section != "" {
+		where += " (" + issue.Section + ")"
+	}
+	return where + ": " + issue.Reason
+}
+
+// Header cells of every section from the last sheet that passed validation,
+// used to notice when columns get moved around. They are saved in headersFile
+// so the check still works on the first fetch after a restart.
+var knownHeaders = make(map[string][]string)
+
+// Problems found during the last update, shown by !parse-errors
+var parseErrors []sheetIssue
+
+// The last alert sent, so a sheet that stays broken is only reported once
+var lastAlert string
+
+// Alerts raised before the Discord connection was opened
+var pendingAlerts []string
+
+// validateSpreadsheet checks that every section in sheetLayout is where we
+// expect it to be and holds the kind of values we expect. An empty result
+// means the spreadsheet is safe to parse.
+func validateSpreadsheet(spreadsheet *sheets.Spreadsheet) []sheetIssue {
+	var issues []sheetIssue
+
+	for _, tab := range sheetLayout {
+		sheet := findSheet(spreadsheet, tab.Title)
+		if sheet == nil && tab.Optional {
+			continue
+		}
+		if sheet == nil {
+			issues = append(issues, sheetIssue{Tab: tab.Title, Row: -1, Column: -1, Reason: "tab is missing"})
+			continue
+		}
+		if len(sheet.Data) == 0 {
+			issues = append(issues, sheetIssue{Tab: tab.Title, Row: -1, Column: -1, Reason: "tab has no data"})
+			continue
+		}
+		rowData := sheet.Data[0].RowData
+		for _, sec := range tab.Sections {
+			issues = append(issues, validateSection(tab.Title, rowData, sec)...)
+		}
+	}
+	return issues
+}
+
+// validateSection checks one section's header, row count and column types
+func validateSection(tab string, rowData []*sheets.RowData, sec section) []sheetIssue {
+	var issues []sheetIssue
+	issue := func(row int, col int, format string, args ...interface{}) {
+		issues = append(issues, sheetIssue{Tab: tab, Section: sec.Key, Row: row, Column: col, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	// The header should not have changed since the last good sheet
+	if known, ok := knownHeaders[sec.Key]; ok {
+		headers := sectionHeaders(rowData, sec)
+		for i := range headers {
+			if headers[i] != known[i] {
+				issue(sec.StartRow-1, sec.StartCol+i, "header changed from %q to %q", known[i], headers[i])
+			}
+		}
+	}
+
+	// Every row needs a name, a time/score and a holder
+	if len(rowData) < sec.StartRow+sec.Amount {
+		present := len(rowData) - sec.StartRow
+		if present < 0 {
+			present = 0
+		}
+		issue(-1, -1, "expected %d rows starting at row %d, found %d", sec.Amount, sec.StartRow+1, present)
+	}
+	for i := sec.StartRow; i < sec.StartRow+sec.Amount && i < len(rowData); i++ {
+		if rowData[i] == nil || len(rowData[i].Values) < sec.StartCol+3 {
+			cells := 0
+			if rowData[i] != nil {
+				cells = len(rowData[i].Values)
+			}
+			issue(i, -1, "row has %d cells, expected at least %d", cells, sec.StartCol+3)
+			continue
+		}
+		name := cellText(rowData, i, sec.StartCol)
+		value := cellText(rowData, i, sec.StartCol+1)
+		holder := cellText(rowData, i, sec.StartCol+2)
+
+		if name == "" {
+			issue(i, sec.StartCol, "stage name is empty")
+		}
+		if value != "" && value != "N/A" {
+			if sec.IsTime {
+				if _, err := parseTimeValue(value); err != nil {
+					issue(i, sec.StartCol+1, "expected a time, found %q", value)
+				}
+			} else if _, err := parseScoreValue(value); err != nil {
+				issue(i, sec.StartCol+1, "expected a score, found %q", value)
+			}
+		}
+		if _, err := strconv.ParseFloat(strings.Replace(holder, ",", "", -1), 64); err == nil {
+			issue(i, sec.StartCol+2, "expected a holder name, found %q", holder)
+		}
+	}
+	return issues
+}
+
+// brokenSections returns the keys of every section with an issue. An issue
+// about a whole tab breaks every section in it.
+func brokenSections(issues []sheetIssue) map[string]bool {
+	broken := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.Section != "" {
+			broken[issue.Section] = true
+			continue
+		}
+		if tab := findTabLayout(issue.Tab); tab != nil {
+			for _, sec := range tab.Sections {
+				broken[sec.Key] = true
+			}
+		}
+	}
+	return broken
+}
+
+// rememberHeaders stores the headers of every section that passed validation
+// so later changes to them can be detected
+func rememberHeaders(spreadsheet *sheets.Spreadsheet, broken map[string]bool) {
+	for _, tab := range sheetLayout {
+		sheet := findSheet(spreadsheet, tab.Title)
+		if sheet == nil || len(sheet.Data) == 0 {
+			continue
+		}
+		for _, sec := range tab.Sections {
+			if !broken[sec.Key] {
+				knownHeaders[sec.Key] = sectionHeaders(sheet.Data[0].RowData, sec)
+			}
+		}
+	}
+}
+
+// loadKnownHeaders reads the headers of the last good sheet from headersFile.
+// A missing file just means the sheet hasn't been checked before.
+func loadKnownHeaders() error {
+	data, err := ioutil.ReadFile(*headersFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &knownHeaders)
+}
+
+// reportIssues logs every issue and alerts the admins about them
+func reportIssues(issues []sheetIssue) {
+	log.Println("IL sheet check found problems:")
+	for _, issue := range issues {
+		log.Println("  " + issue.String())
+	}
+	alertAdmins(formatIssues("IL sheet check failed, keeping the previous records for these sections:\n", issues))
+}
+
+// formatIssues lists issues under header, short enough for a Discord message
+func formatIssues(header string, issues []sheetIssue) string {
+	text := header
+	for i, issue := range issues {
+		if i == maxAlertIssues {
+			text += "...and " + strconv.Itoa(len(issues)-maxAlertIssues) + " more\n"
+			break
+		}
+		text += issue.String() + "\n"
+	}
+	return text
+}
+
+// alertAdmins posts a message in the alert channel, if there is one. The same
+// alert is never sent twice in a row.
+func alertAdmins(alert string) {
+	if *alertChannel == "" || alert == lastAlert {
+		return
+	}
+	lastAlert = alert
+
+	// Wait until Discord is connected
+	if discordSession == nil {
+		pendingAlerts = append(pendingAlerts, alert)
+		return
+	}
+	_, err := discordSession.ChannelMessageSend(*alertChannel, alert)
+	if err != nil {
+		fmt.Println("error sending alert,", err)
+	}
+}
+
+// sendPendingAlerts posts the alerts raised before Discord was connected
+func sendPendingAlerts() {
+	alerts := pendingAlerts
+	pendingAlerts = nil
+	for _, alert := range alerts {
+		_, err := discordSession.ChannelMessageSend(*alertChannel, alert)
+		if err != nil {
+			fmt.Println("error sending alert,", err)
+		}
+	}
+}
+
+// findSheet returns the tab with the given title
+func findSheet(spreadsheet *sheets.Spreadsheet, title string) *sheets.Sheet {
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == title {
+			return sheet
+		}
+	}
+	return nil
+}
+
+// sectionHeaders returns the three header cells above a section
+func sectionHeaders(rowData []*sheets.RowData, sec section) []string {
+	headers := make([]string, 3)
+	for i := range headers {
+		headers[i] = cellText(rowData, sec.StartRow-1, sec.StartCol+i)
+	}
+	return headers
+}
+
+// cellAt returns the cell at row and col, or nil if the sheet doesn't have it
+func cellAt(rowData []*sheets.RowData, row int, col int) *sheets.CellData {
+	if row < 0 || row >= len(rowData) || rowData[row] == nil {
+		return nil
+	}
+	if col < 0 || col >= len(rowData[row].Values) {
+		return nil
+	}
+	return rowData[row].Values[col]
+}
+
+// cellText returns the formatted value of a cell, or "" if it doesn't exist
+func cellText(rowData []*sheets.RowData, row int, col int) string {
+	cell := cellAt(rowData, row, col)
+	if cell == nil {
+		return ""
+	}
+	return cell.FormattedValue
+}
+
+// cellName converts zero based coordinates into A1 notation (ex: 2, 3 is D3)
+func cellName(row int, col int) string {
+	return columnName(col) + strconv.Itoa(row+1)
+}
+
+// columnName converts a zero based column into its letters (ex: 27 is AB)
+func columnName(col int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name
+}
+
+// parseTimeValue converts a time from the sheet (ex: 59.98 or 1:02.50) into
+// seconds. Only digits and a decimal point are allowed, so values like NaN,
+// Inf, -1 or 1e3 aren't taken for times.
+func parseTimeValue(value string) (float64, error) {
+	if value == "" || strings.Trim(value, "0123456789.:") != "" {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	minutes := 0
+	if colon := strings.Index(value, ":"); colon >= 0 {
+		var err error
+		minutes, err = strconv.Atoi(value[:colon])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		value = value[colon+1:]
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return float64(minutes)*60 + seconds, nil
+}
+
+// parseScoreValue converts a score from the sheet (ex: 12,345) into a number
+func parseScoreValue(value string) (int, error) {
+	score, err := strconv.Atoi(strings.Replace(value, ",", "", -1))
+	if err != nil || score < 0 {
+		return 0, fmt.Errorf("invalid score %q", value)
+	}
+	return score, nil
+}
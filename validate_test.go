@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseTimeValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		seconds float64
+		ok      bool
+	}{
+		{"59.98", 59.98, true},
+		{"1:02.50", 62.5, true},
+		{"12", 12, true},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"-1", 0, false},
+		{"-1:00.00", 0, false},
+		{"1e3", 0, false},
+		{"1:2:3", 0, false},
+	}
+	for _, test := range tests {
+		seconds, err := parseTimeValue(test.value)
+		if (err == nil) != test.ok || seconds != test.seconds {
+			t.Errorf("%q: got %v %v, want %v (ok %t)", test.value, seconds, err, test.seconds, test.ok)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reply for a missing video query that can't be understood
+const noVideoUsage = "Usage: !novideo [game] [check] (ex: !novideo, !novideo smb2 check)"
+
+// videoAuditEntry is a record that is missing its video or has a bad link
+type videoAuditEntry struct {
+	Section section
+	Level   int
+	Record  Record
+	Problem string
+}
+
+// String describes the entry on one line (ex: "e12 (Name) 12.34 by bob")
+func (entry videoAuditEntry) String() string {
+	text := stageCode(entry.Section.Game, entry.Section.Category(), entry.Level) + " (" + entry.Record.Name + ") " + entry.Record.Time + " by " + entry.Record.Holder
+	if entry.Problem != "" {
+		text += ": " + entry.Problem + " <" + entry.Record.Video + ">"
+	}
+	return text
+}
+
+// auditVideos finds every record of the given games that has a holder but no
+// video and, if checkLinks is set, every record whose video link looks wrong
+func auditVideos(games []string, checkLinks bool) []videoAuditEntry {
+	var entries []videoAuditEntry
+	for _, sec := range recordSections() {
+		section, ok := records[sec.Key]
+		if !ok || !hasGame(games, sec.Game) {
+			continue
+		}
+		for level := 1; level < len(section); level++ {
+			record := section[level]
+			if record.Holder == "" || record.Time == "N/A" {
+				continue
+			}
+			if record.Video == "" {
+				entries = append(entries, videoAuditEntry{Section: sec, Level: level, Record: record})
+			} else if checkLinks {
+				if problem := checkVideoLink(record.Video); problem != "" {
+					entries = append(entries, videoAuditEntry{Section: sec, Level: level, Record: record, Problem: problem})
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// checkVideoLink returns what is wrong with a video link, or "" if it looks
+// like a link to a video on a known site
+func checkVideoLink(link string) string {
+	video, err := parseVideoLink(link)
+	if err != nil {
+		return err.Error()
+	}
+	if video.Platform == "" {
+		return "not on a known video site"
+	}
+	return ""
+}
+
+// formatVideoAudit groups audit entries by game, difficulty and score type
+func formatVideoAudit(entries []videoAuditEntry) string {
+	text := ""
+	lastKey := ""
+	for _, entry := range entries {
+		if entry.Section.Key != lastKey {
+			text += "**" + gameDisplayName(entry.Section.Game) + " " + categoryLabel(entry.Section.Game, entry.Section.Category()) + " " + entry.Section.ScoreType() + "**\n"
+			lastKey = entry.Section.Key
+		}
+		text += entry.String() + "\n"
+	}
+	return text
+}
+
+// buildNoVideoReply lists the records that are missing a video
+func buildNoVideoReply(message string, games []string) string {
+	checkLinks := false
+	var args []string
+	for _, arg := range strings.Fields(message)[1:] {
+		if strings.ToLower(arg) == "check" {
+			checkLinks = true
+		} else {
+			args = append(args, arg)
+		}
+	}
+	games, scoreType, ok := parseGameAndType(args, games)
+	if !ok || scoreType != "" {
+		return noVideoUsage
+	}
+
+	entries := auditVideos(games, checkLinks)
+	if len(entries) == 0 {
+		return "Every record has a video"
+	}
+	return formatVideoAudit(entries)
+}
+
+// runNoVideo prints the missing video report from the terminal
+//
+//	scorebot novideo
+//	scorebot novideo -game smb2 -check
+func runNoVideo(args []string) {
+	noVideoFlags := flag.NewFlagSet("novideo", flag.ExitOnError)
+	game := noVideoFlags.String("game", "", "Only report records for this game ("+gameNameList()+")")
+	checkLinks := noVideoFlags.Bool("check", false, "Also report video links that don't look right")
+	offline := noVideoFlags.Bool("offline", false, "Use the cached records instead of fetching the sheet")
+	noVideoFlags.Parse(args)
+
+	games := commandGames(*game)
+
+	loadCommandRecords(*offline)
+
+	entries := auditVideos(games, *checkLinks)
+	fmt.Print(formatVideoAudit(entries))
+	fmt.Fprintf(os.Stderr, "%d records\n", len(entries))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	sheets "google.golang.org/api/sheets/v4"
+)
+
+// Fields needed to find a tab's id and read the cells about to be replaced
+const writeBackFields = "sheets(properties(sheetId,title),data(rowData(values(formattedValue,hyperlink))))"
+
+// writeAuditEntry is one line of the write-back audit log
+type writeAuditEntry struct {
+	Time         time.Time `json:"time"`
+	SubmissionID int       `json:"submissionId"`
+	Moderator    string    `json:"moderator"`
+	Range        string    `json:"range"`
+	OldValue     string    `json:"oldValue"`
+	OldVideo     string    `json:"oldVideo"`
+	OldHolder    string    `json:"oldHolder"`
+	NewValue     string    `json:"newValue"`
+	NewVideo     string    `json:"newVideo"`
+	NewHolder    string    `json:"newHolder"`
+	DryRun       bool      `json:"dryRun"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// findRecordCell returns the tab and zero based row and column of the cell a
+// stage's time or score is in. The holder is in the column after it.
+func findRecordCell(stage stageRef, isTime bool) (tab string, row int, col int, err error) {
+	mapKey := stage.Game + stage.Category + scoreTypeKey(isTime)
+	for _, tabLayout := range sheetLayout {
+		for _, sec := range tabLayout.Sections {
+			if sec.Key != mapKey {
+				continue
+			}
+			if stage.Level < 1 || stage.Level > sec.Amount {
+				return "", 0, 0, fmt.Errorf("%s has no stage %d", mapKey, stage.Level)
+			}
+			return tabLayout.Title, sec.StartRow + stage.Level - 1, sec.StartCol + 1, nil
+		}
+	}
+	return "", 0, 0, fmt.Errorf("no section %s in the sheet layout", mapKey)
+}
+
+// sheetHolderName returns how a Discord user is written as a holder in the
+// sheet, ignoring case, or "" if they don't hold any records yet
+func sheetHolderName(username string) string {
+	for _, section := range records {
+		for _, record := range section {
+			if record.Holder != "" && strings.EqualFold(record.Holder, username) {
+				return record.Holder
+			}
+		}
+	}
+	return ""
+}
+
+// currentRecord describes a record the same way a submission's Previous does
+func currentRecord(value string, holder string) string {
+	if holder == "" {
+		return "none"
+	}
+	return value + " by " + holder
+}
+
+// sheetNumber formats a time or score as a number for a formula, so the
+// sheet keeps treating it as one. Times are in seconds, except m:ss.cc times
+// which are durations (a fraction of a day) like the sheet's own.
+func sheetNumber(isTime bool, value string) (string, error) {
+	number, err := recordValue(isTime, value)
+	if err != nil {
+		return "", err
+	}
+	if isTime && strings.Contains(value, ":") {
+		return strconv.FormatFloat(number, 'f', 2, 64) + "/86400", nil
+	}
+	if isTime {
+		return strconv.FormatFloat(number, 'f', 2, 64), nil
+	}
+	return strconv.FormatFloat(number, 'f', 0, 64), nil
+}
+
+// sheetFormula quotes a string for use inside a sheet formula
+func sheetFormula(value string) string {
+	return `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+}
+
+// writeRecord writes an approved submission into the sheet: the time or
+// score (linked to the video) and the holder. If the record in the sheet
+// isn't the one the submission beat, nothing is written. In dry run mode
+// nothing is changed. Every attempt is written to the audit log.
+func writeRecord(sub submission) (string, error) {
+	tab, row, col, err := findRecordCell(sub.Stage(), sub.IsTime)
+	if err != nil {
+		return "", err
+	}
+	a1 := "'" + strings.Replace(tab, "'", "''", -1) + "'!" + cellName(row, col) + ":" + cellName(row, col+1)
+	entry := writeAuditEntry{Time: time.Now().UTC(), SubmissionID: sub.ID, Moderator: sub.Moderator, Range: a1, NewValue: sub.Value, NewVideo: sub.Video, NewHolder: sub.Holder, DryRun: *writeDryRun}
+
+	err = writeCells(&entry, tab, row, col, sub.IsTime, sub.Previous)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if logErr := logWrite(entry); logErr != nil {
+		fmt.Println("error writing to the write-back audit log,", logErr)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	replaced := entry.OldValue + " by " + entry.OldHolder
+	if entry.OldHolder == "" {
+		replaced = "an open record"
+	}
+	if entry.DryRun {
+		return "Dry run: would write " + sub.Value + " by " + sub.Holder + " to " + a1 + ", replacing " + replaced, nil
+	}
+	return "Wrote " + sub.Value + " by " + sub.Holder + " to " + a1 + ", replacing " + replaced, nil
+}
+
+// writeCells reads the current contents of the record's cells into entry and,
+// if they still hold the previous record and this isn't a dry run, replaces
+// them with the new record
+func writeCells(entry *writeAuditEntry, tab string, row int, col int, isTime bool, previous string) error {
+	label, err := sheetNumber(isTime, entry.NewValue)
+	if err != nil {
+		return fmt.Errorf("%s isn't a %s: %v", entry.NewValue, scoreTypeName(isTime), err)
+	}
+
+	svc, err := newSheetsService()
+	if err != nil {
+		return fmt.Errorf("unable to create Sheets service: %v", err)
+	}
+	sheetID := valueOrFileContents(*sheet, *sheetFile)
+
+	// Find the tab's id and what is in the cells right now
+	current, err := svc.Spreadsheets.Get(sheetID).Ranges(entry.Range).IncludeGridData(true).Fields(writeBackFields).Do()
+	if err != nil {
+		return err
+	}
+	tabSheet := findSheet(current, tab)
+	if tabSheet == nil {
+		return fmt.Errorf("tab %q is missing", tab)
+	}
+	if len(tabSheet.Data) > 0 {
+		rowData := tabSheet.Data[0].RowData
+		if cell := cellAt(rowData, 0, 0); cell != nil {
+			entry.OldValue = cell.FormattedValue
+			entry.OldVideo = cell.Hyperlink
+		}
+		entry.OldHolder = cellText(rowData, 0, 1)
+	}
+	// Someone else may have changed the record since it was submitted
+	if current := currentRecord(entry.OldValue, entry.OldHolder); current != previous {
+		return fmt.Errorf("the record changed from %s to %s since it was submitted", previous, current)
+	}
+	if entry.DryRun {
+		return nil
+	}
+
+	// The video is a link on the time or score, like the rest of the sheet
+	formula := "=HYPERLINK(" + sheetFormula(entry.NewVideo) + ", " + label + ")"
+	holder := entry.NewHolder
+	update := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			UpdateCells: &sheets.UpdateCellsRequest{
+				Start: &sheets.GridCoordinate{SheetId: tabSheet.Properties.SheetId, RowIndex: int64(row), ColumnIndex: int64(col)},
+				Rows: []*sheets.RowData{{
+					Values: []*sheets.CellData{
+						{UserEnteredValue: &sheets.ExtendedValue{FormulaValue: &formula}},
+						{UserEnteredValue: &sheets.ExtendedValue{StringValue: &holder}},
+					},
+				}},
+				Fields: "userEnteredValue",
+			},
+		}},
+	}
+	_, err = svc.Spreadsheets.BatchUpdate(sheetID, update).Do()
+	return err
+}
+
+// logWrite appends an entry to the write-back audit log as a line of JSON
+func logWrite(entry writeAuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(*writeLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	_, err = logFile.Write(append(data, '\n'))
+	return err
+}